@@ -4,41 +4,87 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 )
 
 
+// SELECT语句的各部分
+type selectParts struct {
+	Fields  []string
+	Table   string
+	Joins   []joinItem
+	Where   *Cond
+	GroupBy []string
+	Having  *Cond
+	Order   []orderItem
+	Limit   [2]int
+}
+
+type joinItem struct {
+	Typ   string // JOIN, LEFT JOIN, RIGHT JOIN
+	Table string
+	On    string
+	Args  []interface{}
+}
 
-func buildSelectSql(fields []string, tableName string,
-where map[string]([]interface{}), order []orderItem, limit [2]int ) (sql string, args []interface{}, err error) {
+func buildSelectSql(d Dialect, parts selectParts) (sql string, args []interface{}, err error) {
+	sql, args = selectSql(d, parts)
+	sql = rebind(d, sql)
+	err = nil
+	return
+}
+
+// 生成未替换占位符的SELECT语句, 方便作为子查询
+func selectSql(d Dialect, parts selectParts) (sql string, args []interface{}) {
 	args = []interface{}{}
 	sql = "SELECT "
 
 	//field
 	fieldString := "*"
-	if fields != nil && len(fields) != 0 {
-		fieldString =  strings.Join(fields, ",")
+	if len(parts.Fields) != 0 {
+		fields := parts.Fields
+		if len(parts.Joins) != 0 {
+			// 连表时 a.b 形式的字段以 a.b 作为结果的键
+			fields = make([]string, len(parts.Fields))
+			for i, f := range parts.Fields {
+				if isQualifiedColumn(f) {
+					f = quoteName(d, f) + " AS " + d.Quote(f)
+				}
+				fields[i] = f
+			}
+		}
+		fieldString = strings.Join(fields, ",")
 	}
 
 	sql = sql + fieldString + " "
 
 	//table
-	sql = sql + "FROM `" + tableName + "` "
+	fromString, as := buildFrom(d, parts)
+	args = append(args, as...)
+	sql = sql + fromString
 
 	//where
-	if where != nil {
-		whereString, as := buildWhere(where)
-		for _, a := range as {
-			args = append(args, a)
-		}
-
+	if whereString, as := parts.Where.build(d); whereString != "" {
+		args = append(args, as...)
 		sql = sql + "WHERE " + whereString + " "
 	}
 
+	//groupBy
+	if len(parts.GroupBy) != 0 {
+		sql = sql + "GROUP BY " + strings.Join(parts.GroupBy, ",") + " "
+
+		//having
+		if havingString, as := parts.Having.build(d); havingString != "" {
+			args = append(args, as...)
+			sql = sql + "HAVING " + havingString + " "
+		}
+	}
+
 	//orderBy
-	if order != nil {
+	if len(parts.Order) != 0 {
 		orderString := ""
-		for _, value := range order {
+		for _, value := range parts.Order {
 			orderString = orderString + "," + value.Field + " " + value.Desc
 		}
 		orderString = orderString[1:]
@@ -47,15 +93,25 @@ where map[string]([]interface{}), order []orderItem, limit [2]int ) (sql string,
 	}
 
 	//limit
-	if limit[0] != 0 || limit[1] != 0 {
-		sql = sql + "LIMIT " + fmt.Sprintf("%d,%d", limit[0], limit[1]) + " "
+	if parts.Limit[0] != 0 || parts.Limit[1] != 0 {
+		sql = sql + d.Limit(parts.Limit[0], parts.Limit[1]) + " "
 	}
 
-	err = nil
 	return
 }
 
-func buildInsertSql(tableName string, saveData map[string]interface{}) (sql string, args []interface{}, err error) {
+// FROM table JOIN ... ON ...
+func buildFrom(d Dialect, parts selectParts) (sql string, args []interface{}) {
+	sql = "FROM " + quoteTable(d, parts.Table) + " "
+	for _, join := range parts.Joins {
+		sql = sql + join.Typ + " " + quoteTable(d, join.Table) + " ON " + join.On + " "
+		args = append(args, join.Args...)
+	}
+	return
+}
+
+// pk不为空并且方言支持时, 会附加RETURNING子句取回主键
+func buildInsertSql(d Dialect, tableName string, saveData map[string]interface{}, pk string) (sql string, args []interface{}, err error) {
 	if saveData==nil||len(saveData) == 0 {
 		err = errors.New("no save data on INSERT")
 		return
@@ -66,26 +122,97 @@ func buildInsertSql(tableName string, saveData map[string]interface{}) (sql stri
 	}
 
 	args = []interface{}{}
-	sql = "INSERT INTO " + tableName + " ("
+	tableName, _ = splitTable(tableName)
+	sql = "INSERT INTO " + quoteName(d, tableName) + " ("
 
 	var fields bytes.Buffer
 	var holder bytes.Buffer
 
-	for key, value := range saveData {
-		fields.WriteString(",`" + key + "`")
+	for _, key := range sortedKeys(saveData) {
+		fields.WriteString("," + d.Quote(key))
 		holder.WriteString(",?")
-		args = append(args, value)
+		args = append(args, saveData[key])
 	}
 
 	fieldsStr := fields.String()[1:]
 	holderStr := holder.String()[1:]
 
 	sql = sql + fieldsStr + " ) VALUES ( " + holderStr + " )"
+	if pk != "" {
+		if returning := d.Returning(pk); returning != "" {
+			sql = sql + " " + returning
+		}
+	}
 
+	sql = rebind(d, sql)
 	return
 }
 
-func buildUpdateSql(tableName string, saveData map[string]interface{}, where map[string]([]interface{})) (sql string, args []interface{}, err error) {
+// INSERT ... ON DUPLICATE KEY UPDATE / ON CONFLICT DO UPDATE
+func buildUpsertSql(d Dialect, tableName string, saveData map[string]interface{}, conflict, update []string, pk string) (sql string, args []interface{}, err error) {
+	if len(update) == 0 {
+		err = errors.New("no update fields on UPSERT")
+		return
+	}
+	sql, args, err = buildInsertSql(d, tableName, saveData, "")
+	if err != nil {
+		return
+	}
+	clause, err := d.Upsert(conflict, update)
+	if err != nil {
+		return
+	}
+	sql = sql + " " + clause
+	if pk != "" {
+		if returning := d.Returning(pk); returning != "" {
+			sql = sql + " " + returning
+		}
+	}
+	return
+}
+
+// 多行插入, rows中每一行的列必须相同, 列取第一行的
+func buildInsertMultiSql(d Dialect, tableName string, rows []map[string]interface{}, pk string) (sql string, args []interface{}, err error) {
+	if len(rows) == 0 || len(rows[0]) == 0 {
+		err = errors.New("no save data on INSERT")
+		return
+	}
+	if tableName == "" {
+		err = errors.New("not set table name")
+		return
+	}
+
+	keys := sortedKeys(rows[0])
+	args = make([]interface{}, 0, len(keys)*len(rows))
+	tableName, _ = splitTable(tableName)
+	sql = "INSERT INTO " + quoteName(d, tableName) + " ("
+
+	var fields bytes.Buffer
+	for _, key := range keys {
+		fields.WriteString("," + d.Quote(key))
+	}
+	holder := "( " + strings.Repeat(",?", len(keys))[1:] + " )"
+
+	var values bytes.Buffer
+	for _, row := range rows {
+		values.WriteString("," + holder)
+		for _, key := range keys {
+			args = append(args, row[key])
+		}
+	}
+
+	sql = sql + fields.String()[1:] + " ) VALUES " + values.String()[1:]
+	if pk != "" {
+		if returning := d.Returning(pk); returning != "" {
+			sql = sql + " " + returning
+		}
+	}
+
+	sql = rebind(d, sql)
+	return
+}
+
+func buildUpdateSql(d Dialect, tableName string, saveData map[string]interface{}, where *Cond) (sql string, args []interface{}, err error) {
 
 	if len(saveData) == 0 {
 		err = errors.New("no save data on INSERT")
@@ -93,74 +220,118 @@ func buildUpdateSql(tableName string, saveData map[string]interface{}, where map
 	}
 
 	args = []interface{}{}
-	sql = "UPDATE " + tableName + " SET "
+	sql = "UPDATE " + quoteTable(d, tableName) + " SET "
 
 	//value
 	var fields bytes.Buffer
 
-	for key, value := range saveData {
-		fields.WriteString(",`" + key + "`=?")
-		args = append(args, value)
+	for _, key := range sortedKeys(saveData) {
+		fields.WriteString("," + d.Quote(key) + "=?")
+		args = append(args, saveData[key])
 	}
 
 	fieldsStr := fields.String()[1:]
 	sql = sql + fieldsStr + " "
 
 	//where
-	if where != nil {
-		whereString, as := buildWhere(where)
+	if whereString, as := where.build(d); whereString != "" {
 		for _, a := range as {
 			args = append(args, a)
 		}
 		sql = sql + "WHERE " + whereString + " "
 	}
 
+	sql = rebind(d, sql)
 	return
 }
 
-func buildDeleteSql(tableName string, where map[string]([]interface{})) (sql string, args []interface{}, err error) {
+func buildDeleteSql(d Dialect, tableName string, where *Cond) (sql string, args []interface{}, err error) {
 	args = []interface{}{}
-	sql = "DELETE FROM " + tableName + " "
+	sql = "DELETE FROM " + quoteTable(d, tableName) + " "
+	if _, alias := splitTable(tableName); alias != "" {
+		if _, ok := d.(MysqlDialect); ok {
+			// mysql中带别名的DELETE需要写成 DELETE a FROM t AS a
+			sql = "DELETE " + d.Quote(alias) + " FROM " + quoteTable(d, tableName) + " "
+		}
+	}
 
 	//where
-	if where != nil {
-		whereString, as := buildWhere(where)
+	if whereString, as := where.build(d); whereString != "" {
 		args = as
 		sql = sql + "WHERE (" + whereString + ") "
 	}
+	sql = rebind(d, sql)
 	err = nil
 	return
 }
 
-func buildCountSql(tableName string, where map[string]([]interface{})) (sql string, args []interface{}, err error) {
-	sql = "SELECT COUNT(*) as count FROM " + tableName + " "
+// 聚合查询, expr为聚合函数的参数, 如 COUNT(*), SUM(`money`)
+// 有GROUP BY时先在子查询中按组聚合, 再聚合各组的结果:
+// COUNT(*) 统计的是分组数, SUM/MAX/MIN 是满足HAVING的各组的合计/最大/最小值
+// AVG 用各组的SUM与COUNT计算, 而不是各组平均值的平均值
+func buildAggregateSql(d Dialect, fn, expr string, parts selectParts) (sql string, args []interface{}, err error) {
+	if len(parts.GroupBy) != 0 {
+		parts.Order = nil
+		parts.Limit = [2]int{}
+		outer := ""
+		switch {
+		case expr == "*":
+			parts.Fields = []string{"1"}
+			outer = fn + "(*)"
+		case fn == "COUNT":
+			parts.Fields = []string{"COUNT(" + expr + ") AS result"}
+			outer = "SUM(result)"
+		case fn == "AVG":
+			parts.Fields = []string{"SUM(" + expr + ") AS s", "COUNT(" + expr + ") AS c"}
+			// *1.0 避免整数除法, NULLIF 避免所有值都为NULL时除以0
+			outer = "SUM(s)*1.0/NULLIF(SUM(c),0)"
+		case fn == "SUM" || fn == "MAX" || fn == "MIN":
+			parts.Fields = []string{fn + "(" + expr + ") AS result"}
+			outer = fn + "(result)"
+		default:
+			err = fmt.Errorf("aggregate %s is not supported with GROUP BY", fn)
+			return
+		}
+		inner, as := selectSql(d, parts)
+		sql = "SELECT " + outer + " as result FROM (" + inner + ") t "
+		args = as
+		sql = rebind(d, sql)
+		return
+	}
+
+	fromString, args := buildFrom(d, parts)
+	sql = "SELECT " + fn + "(" + expr + ") as result " + fromString
 
 	//where
-	if where != nil {
-		whereString, as := buildWhere(where)
-		args = as
+	if whereString, as := parts.Where.build(d); whereString != "" {
+		args = append(args, as...)
 		sql = sql + "WHERE (" + whereString + ") "
 	}
 
+	sql = rebind(d, sql)
 	err = nil
 	return
 }
 
-// 生成where 条件
-func buildWhere(where map[string]([]interface{})) (whereString string, args []interface{}) {
-	if where != nil {
-		args = []interface{}{}
-		whereString = " "
-
-		for key, values := range where {
-			whereString = whereString + " AND ( " + key + " )"
-			for _, value := range values {
-				args = append(args, value)
-			}
+// 两行数据的列是否相同
+func sameKeys(a, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key := range a {
+		if _, ok := b[key]; !ok {
+			return false
 		}
-
-		whereString = whereString[5:]
 	}
+	return true
+}
 
-	return
+// 排序后的key, 保证相同的数据生成相同的sql
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
\ No newline at end of file
@@ -0,0 +1,166 @@
+package orm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildDeleteSqlAlias(t *testing.T) {
+	cases := []struct {
+		d    Dialect
+		want string
+	}{
+		{MysqlDialect{}, "DELETE `u` FROM `user` AS `u` WHERE (( u.id = ? )) "},
+		{PostgresDialect{}, `DELETE FROM "user" AS "u" WHERE (( u.id = $1 )) `},
+		{SqliteDialect{}, `DELETE FROM "user" AS "u" WHERE (( u.id = ? )) `},
+	}
+	for _, c := range cases {
+		where := &Cond{}
+		where.Where("u.id = ?", 1)
+		sql, args, err := buildDeleteSql(c.d, "user u", where)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sql != c.want {
+			t.Errorf("%T: got %q, want %q", c.d, sql, c.want)
+		}
+		if !reflect.DeepEqual(args, []interface{}{1}) {
+			t.Errorf("%T: args %v", c.d, args)
+		}
+	}
+
+	sql, _, _ := buildDeleteSql(MysqlDialect{}, "user", &Cond{})
+	if sql != "DELETE FROM `user` " {
+		t.Errorf("got %q", sql)
+	}
+}
+
+func selectChain() *WithOutModel {
+	return newWithOutModel().Table("user u").
+		Fields("u.id", "r.name").
+		LeftJoin("role r", "r.id = u.role_id AND r.status = ?", 9).
+		Where("u.age > ?", 18).
+		OrWhere("u.vip = ?", 1).
+		WhereIn("u.id IN (?)", 1, 2, 3).
+		WhereEq("u.status", 1).
+		GroupBy("u.id").
+		Having("COUNT(*) > ?", 2).
+		Order("u.id", "DESC").
+		Limit(10, 20)
+}
+
+func TestBuildSelectSql(t *testing.T) {
+	cases := []struct {
+		d    Dialect
+		want string
+	}{
+		{MysqlDialect{}, "SELECT `u`.`id` AS `u.id`,`r`.`name` AS `r.name` FROM `user` AS `u` LEFT JOIN `role` AS `r` ON r.id = u.role_id AND r.status = ? " +
+			"WHERE ( u.age > ? ) OR ( u.vip = ? ) AND ( u.id IN (?,?,?) ) AND ( `u`.`status` = ? ) GROUP BY u.id HAVING ( COUNT(*) > ? ) ORDER BY u.id DESC LIMIT 10,20 "},
+		{PostgresDialect{}, `SELECT "u"."id" AS "u.id","r"."name" AS "r.name" FROM "user" AS "u" LEFT JOIN "role" AS "r" ON r.id = u.role_id AND r.status = $1 ` +
+			`WHERE ( u.age > $2 ) OR ( u.vip = $3 ) AND ( u.id IN ($4,$5,$6) ) AND ( "u"."status" = $7 ) GROUP BY u.id HAVING ( COUNT(*) > $8 ) ORDER BY u.id DESC LIMIT 20 OFFSET 10 `},
+	}
+	for _, c := range cases {
+		sql, args, err := buildSelectSql(c.d, selectChain().selectParts())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sql != c.want {
+			t.Errorf("%T:\ngot  %q\nwant %q", c.d, sql, c.want)
+		}
+		// 参数顺序: join, where, having
+		if !reflect.DeepEqual(args, []interface{}{9, 18, 1, 1, 2, 3, 1, 2}) {
+			t.Errorf("%T: args %v", c.d, args)
+		}
+	}
+}
+
+// 相同的调用链每次生成完全相同的sql与参数
+func TestBuildSelectSqlDeterministic(t *testing.T) {
+	first, firstArgs, _ := buildSelectSql(MysqlDialect{}, selectChain().selectParts())
+	for i := 0; i < 100; i++ {
+		sql, args, _ := buildSelectSql(MysqlDialect{}, selectChain().selectParts())
+		if sql != first || !reflect.DeepEqual(args, firstArgs) {
+			t.Fatalf("got different sql:\n%q\n%q", sql, first)
+		}
+	}
+}
+
+func TestBuildInsertUpdateSqlSorted(t *testing.T) {
+	data := map[string]interface{}{"c": 3, "a": 1, "b": 2}
+
+	sql, args, err := buildInsertSql(MysqlDialect{}, "t", data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "INSERT INTO `t` (`a`,`b`,`c` ) VALUES ( ?,?,? )"; sql != want {
+		t.Errorf("got  %q\nwant %q", sql, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{1, 2, 3}) {
+		t.Errorf("insert args %v", args)
+	}
+
+	where := &Cond{}
+	where.WhereEq("id", 9)
+	sql, args, err = buildUpdateSql(MysqlDialect{}, "t", data, where)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "UPDATE `t` SET `a`=?,`b`=?,`c`=? WHERE ( `id` = ? ) "; sql != want {
+		t.Errorf("got  %q\nwant %q", sql, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{1, 2, 3, 9}) {
+		t.Errorf("update args %v", args)
+	}
+}
+
+func groupChain() *WithOutModel {
+	return newWithOutModel().Table("user").
+		Where("age > ?", 18).
+		GroupBy("role_id").
+		Having("COUNT(*) > ?", 2).
+		Order("role_id", "DESC").
+		Limit(0, 10)
+}
+
+func TestBuildAggregateSql(t *testing.T) {
+	cases := []struct {
+		fn, expr string
+		group    bool
+		want     string
+	}{
+		{"COUNT", "*", false, "SELECT COUNT(*) as result FROM `user` WHERE (( age > ? )) "},
+		{"SUM", "`age`", false, "SELECT SUM(`age`) as result FROM `user` WHERE (( age > ? )) "},
+		{"COUNT", "*", true, "SELECT COUNT(*) as result FROM (SELECT 1 FROM `user` WHERE ( age > ? ) GROUP BY role_id HAVING ( COUNT(*) > ? ) ) t "},
+		{"COUNT", "`age`", true, "SELECT SUM(result) as result FROM (SELECT COUNT(`age`) AS result FROM `user` WHERE ( age > ? ) GROUP BY role_id HAVING ( COUNT(*) > ? ) ) t "},
+		{"SUM", "`age`", true, "SELECT SUM(result) as result FROM (SELECT SUM(`age`) AS result FROM `user` WHERE ( age > ? ) GROUP BY role_id HAVING ( COUNT(*) > ? ) ) t "},
+		{"MAX", "`age`", true, "SELECT MAX(result) as result FROM (SELECT MAX(`age`) AS result FROM `user` WHERE ( age > ? ) GROUP BY role_id HAVING ( COUNT(*) > ? ) ) t "},
+		{"AVG", "`age`", true, "SELECT SUM(s)*1.0/NULLIF(SUM(c),0) as result FROM (SELECT SUM(`age`) AS s,COUNT(`age`) AS c FROM `user` WHERE ( age > ? ) GROUP BY role_id HAVING ( COUNT(*) > ? ) ) t "},
+	}
+	for _, c := range cases {
+		w := groupChain()
+		if !c.group {
+			w = newWithOutModel().Table("user").Where("age > ?", 18)
+		}
+		sql, args, err := buildAggregateSql(MysqlDialect{}, c.fn, c.expr, w.selectParts())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sql != c.want {
+			t.Errorf("%s(%s):\ngot  %q\nwant %q", c.fn, c.expr, sql, c.want)
+		}
+		want := []interface{}{18}
+		if c.group {
+			want = append(want, 2)
+		}
+		if !reflect.DeepEqual(args, want) {
+			t.Errorf("%s(%s): args %v", c.fn, c.expr, args)
+		}
+	}
+
+	// postgres的占位符按where, having的顺序编号
+	sql, _, _ := buildAggregateSql(PostgresDialect{}, "SUM", `"age"`, groupChain().selectParts())
+	want := `SELECT SUM(result) as result FROM (SELECT SUM("age") AS result FROM "user" WHERE ( age > $1 ) GROUP BY role_id HAVING ( COUNT(*) > $2 ) ) t `
+	if sql != want {
+		t.Errorf("got  %q\nwant %q", sql, want)
+	}
+}
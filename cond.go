@@ -0,0 +1,251 @@
+package orm
+
+import (
+	"errors"
+	"strings"
+)
+
+// 查询条件, 按调用顺序组装where
+// 支持 AND/OR, NOT 以及用括号嵌套的条件组
+type Cond struct {
+	items   []condItem
+	err     error
+	columns map[string]string // 字段名 => 列名, 用于WithModel中将struct字段名转换为列名
+	table   string            // 不为空时给columns中的列加上该表名(或别名)前缀, 用于连表查询
+}
+
+type condItem struct {
+	Or        bool // 与前一个条件用OR连接, 否则用AND
+	Not       bool
+	Column    string // 不为空时, 条件为 引用后的Column + " " + Condition
+	Condition string
+	Args      []interface{}
+	Group     *Cond // 不为nil时是一个条件组
+}
+
+func (p *Cond) add(item condItem) *Cond {
+	p.items = append(p.items, item)
+	return p
+}
+
+// AND condition
+func (p *Cond) Where(condition string, args ...interface{}) *Cond {
+	return p.add(condItem{Condition: condition, Args: args})
+}
+
+// OR condition
+func (p *Cond) OrWhere(condition string, args ...interface{}) *Cond {
+	return p.add(condItem{Or: true, Condition: condition, Args: args})
+}
+
+// AND NOT (condition)
+func (p *Cond) WhereNot(condition string, args ...interface{}) *Cond {
+	return p.add(condItem{Not: true, Condition: condition, Args: args})
+}
+
+// 取得列名, 在WithModel中可以使用struct的字段名
+func (p *Cond) column(name string) string {
+	if col, ok := p.columns[name]; ok {
+		return col
+	}
+	return name
+}
+
+// 连表查询时本表的列加上表名(或别名)前缀, 其他列原样返回
+func (p *Cond) qualify(col string) string {
+	if p.table == "" || strings.Contains(col, ".") {
+		return col
+	}
+	for _, c := range p.columns {
+		if c == col {
+			return p.table + "." + col
+		}
+	}
+	return col
+}
+
+func (p *Cond) addColumn(column, condition string, args ...interface{}) *Cond {
+	return p.add(condItem{Column: p.column(column), Condition: condition, Args: args})
+}
+
+// column = value
+func (p *Cond) WhereEq(column string, value interface{}) *Cond {
+	return p.addColumn(column, "= ?", value)
+}
+
+// column <> value
+func (p *Cond) WhereNe(column string, value interface{}) *Cond {
+	return p.addColumn(column, "<> ?", value)
+}
+
+// column > value
+func (p *Cond) WhereGt(column string, value interface{}) *Cond {
+	return p.addColumn(column, "> ?", value)
+}
+
+// column >= value
+func (p *Cond) WhereGte(column string, value interface{}) *Cond {
+	return p.addColumn(column, ">= ?", value)
+}
+
+// column < value
+func (p *Cond) WhereLt(column string, value interface{}) *Cond {
+	return p.addColumn(column, "< ?", value)
+}
+
+// column <= value
+func (p *Cond) WhereLte(column string, value interface{}) *Cond {
+	return p.addColumn(column, "<= ?", value)
+}
+
+// column BETWEEN min AND max
+func (p *Cond) WhereBetween(column string, min, max interface{}) *Cond {
+	return p.addColumn(column, "BETWEEN ? AND ?", min, max)
+}
+
+// column LIKE pattern, pattern需要自己带上通配符
+func (p *Cond) WhereLike(column string, pattern string) *Cond {
+	return p.addColumn(column, "LIKE ?", pattern)
+}
+
+// column IS NULL
+func (p *Cond) WhereNull(column string) *Cond {
+	return p.addColumn(column, "IS NULL")
+}
+
+// column IS NOT NULL
+func (p *Cond) WhereNotNull(column string) *Cond {
+	return p.addColumn(column, "IS NOT NULL")
+}
+
+// column IN (args...), 同WhereIn, args为空时不添加条件
+func (p *Cond) WhereInCol(column string, args ...interface{}) *Cond {
+	if len(args) == 0 {
+		return p
+	}
+	s := strings.Repeat(",?", len(args))
+	return p.addColumn(column, "IN ("+s[1:]+")", args...)
+}
+
+// column NOT IN (args...), args为空时不添加条件
+func (p *Cond) WhereNotIn(column string, args ...interface{}) *Cond {
+	if len(args) == 0 {
+		return p
+	}
+	s := strings.Repeat(",?", len(args))
+	return p.addColumn(column, "NOT IN ("+s[1:]+")", args...)
+}
+
+// condition 中的 (?) 会被展开为args个数的占位符
+func (p *Cond) WhereIn(condition string, args ...interface{}) *Cond {
+	if len(args) == 0 {
+		return p
+	}
+	if !strings.Contains(condition, "(?)") {
+		p.err = errors.New("WhereIn condition must contains '(?)'")
+		return p
+	}
+	s := strings.Repeat(",?", len(args))
+	condition = strings.Replace(condition, "(?)", "("+s[1:]+")", -1)
+	return p.Where(condition, args...)
+}
+
+// AND (fn中添加的条件)
+func (p *Cond) WhereGroup(fn func(c *Cond)) *Cond {
+	return p.group(false, fn)
+}
+
+// OR (fn中添加的条件)
+func (p *Cond) OrWhereGroup(fn func(c *Cond)) *Cond {
+	return p.group(true, fn)
+}
+
+func (p *Cond) group(or bool, fn func(c *Cond)) *Cond {
+	sub := &Cond{columns: p.columns}
+	fn(sub)
+	if sub.err != nil {
+		p.err = sub.err
+	}
+	return p.add(condItem{Or: or, Group: sub})
+}
+
+// 条件中有OR时用括号包裹原有的条件, 保证之后用AND添加的条件对所有行生效
+// a OR b => ( a OR b )
+func (p *Cond) wrapOr() {
+	for _, item := range p.items {
+		if item.Or {
+			old := *p
+			*p = Cond{columns: old.columns, table: old.table, err: old.err}
+			p.add(condItem{Group: &old})
+			return
+		}
+	}
+}
+
+// 取得用AND连接的 column = value 条件, 有OR时返回nil
+func (p *Cond) eqValues() map[string]interface{} {
+	values := map[string]interface{}{}
+	for _, item := range p.items {
+		if item.Or {
+			return nil
+		}
+		if item.Column != "" && !item.Not && item.Condition == "= ?" {
+			values[item.Column] = item.Args[0]
+		}
+	}
+	return values
+}
+
+// 没有任何条件
+func (p *Cond) empty() bool {
+	for _, item := range p.items {
+		if item.Group == nil || !item.Group.empty() {
+			return false
+		}
+	}
+	return true
+}
+
+// 生成条件语句与参数, 没有条件时返回""
+func (p *Cond) build(d Dialect) (whereString string, args []interface{}) {
+	if p == nil {
+		return
+	}
+	args = []interface{}{}
+	buf := strings.Builder{}
+	for _, item := range p.items {
+		var s string
+		var as []interface{}
+		if item.Group != nil {
+			g := *item.Group
+			g.table = p.table
+			s, as = g.build(d)
+			if s == "" {
+				continue
+			}
+			s = "( " + s + " )"
+		} else if item.Column != "" {
+			s = "( " + quoteName(d, p.qualify(item.Column)) + " " + item.Condition + " )"
+			as = item.Args
+		} else {
+			s = "( " + item.Condition + " )"
+			as = item.Args
+		}
+		if item.Not {
+			s = "NOT " + s
+		}
+
+		if buf.Len() != 0 {
+			if item.Or {
+				buf.WriteString(" OR ")
+			} else {
+				buf.WriteString(" AND ")
+			}
+		}
+		buf.WriteString(s)
+		args = append(args, as...)
+	}
+
+	whereString = buf.String()
+	return
+}
@@ -0,0 +1,73 @@
+package orm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCondBuild(t *testing.T) {
+	c := &Cond{}
+	c.Where("a = ?", 1).
+		OrWhere("b = ?", 2).
+		WhereNot("c = ?", 3).
+		WhereEq("d", 4).
+		WhereIn("e IN (?)", 5, 6).
+		WhereGroup(func(g *Cond) {
+			g.Where("f = ?", 7).OrWhere("g = ?", 8)
+		}).
+		WhereNull("h")
+
+	sql, args := c.build(MysqlDialect{})
+	want := "( a = ? ) OR ( b = ? ) AND NOT ( c = ? ) AND ( `d` = ? ) AND ( e IN (?,?) ) AND ( ( f = ? ) OR ( g = ? ) ) AND ( `h` IS NULL )"
+	if sql != want {
+		t.Errorf("got  %q\nwant %q", sql, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Errorf("args %v", args)
+	}
+}
+
+// 相同的条件按调用顺序各自保留参数, 不会被合并或去重
+func TestCondDuplicate(t *testing.T) {
+	c := &Cond{}
+	c.Where("id = ?", 1).Where("id = ?", 2).WhereEq("id", 3).WhereEq("id", 3)
+
+	sql, args := c.build(MysqlDialect{})
+	want := "( id = ? ) AND ( id = ? ) AND ( `id` = ? ) AND ( `id` = ? )"
+	if sql != want {
+		t.Errorf("got  %q\nwant %q", sql, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{1, 2, 3, 3}) {
+		t.Errorf("args %v", args)
+	}
+}
+
+func TestCondEmptyGroup(t *testing.T) {
+	c := &Cond{}
+	c.WhereGroup(func(g *Cond) {})
+	if !c.empty() {
+		t.Error("cond with only an empty group should be empty")
+	}
+	if sql, _ := c.build(MysqlDialect{}); sql != "" {
+		t.Errorf("got %q", sql)
+	}
+
+	var nilCond *Cond
+	if sql, args := nilCond.build(MysqlDialect{}); sql != "" || args != nil {
+		t.Errorf("nil cond got %q %v", sql, args)
+	}
+}
+
+func TestCondWhereInCol(t *testing.T) {
+	c := &Cond{columns: map[string]string{"RoleId": "role_id"}}
+	c.WhereInCol("RoleId", 1, 2).WhereInCol("u.id", 3).WhereInCol("name")
+
+	sql, args := c.build(MysqlDialect{})
+	want := "( `role_id` IN (?,?) ) AND ( `u`.`id` IN (?) )"
+	if sql != want {
+		t.Errorf("got  %q\nwant %q", sql, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{1, 2, 3}) {
+		t.Errorf("args %v", args)
+	}
+}
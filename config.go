@@ -1,15 +1,21 @@
 package orm
 
 import (
-	"errors"
 	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
 )
 
 type Config map[string]Connect
 
 var config = Config{}
+var configLock sync.RWMutex
 
 func (p *Config) writeConnect(connect string) (conn *Connect, err error) {
+	configLock.RLock()
+	defer configLock.RUnlock()
 	m := map[string]Connect(*p)
 	if c, ok := m[connect + "-write"]; ok {
 		conn = &c
@@ -19,28 +25,89 @@ func (p *Config) writeConnect(connect string) (conn *Connect, err error) {
 		conn = &c
 		return
 	}
-	err = errors.New("can't found connect: " + connect)
+	err = fmt.Errorf("%w: %s", ErrConnectNotFound, connect)
 	return
 }
 
-func (p *Config) readConnect(connect string) (conn *Connect, err error) {
+// 取得读连接, 有多个从库(名字是 connect-read 或 connect-read 加数字)时按权重轮询, 跳过被剔除的从库
+// 没有可用的从库时使用主库, replica表示conn是否是从库
+func (p *Config) readConnect(connect string) (conn *Connect, replica bool, err error) {
+	configLock.RLock()
 	m := map[string]Connect(*p)
-	if c, ok := m[connect + "-read"]; ok {
-		conn = &c
-		return
+	names := []string{}
+	for name := range m {
+		if base, ok := readBase(name); ok && base == connect {
+			names = append(names, name)
+		}
 	}
-	if c, ok := m[connect]; ok {
-		conn = &c
+	sort.Strings(names)
+	replicas := make([]Connect, len(names))
+	for i, name := range names {
+		replicas[i] = m[name]
+	}
+	configLock.RUnlock()
+
+	if conn = pickReplica(connect, replicas); conn != nil {
+		replica = true
 		return
 	}
-	err = errors.New("can't found connect: " + connect)
+	conn, err = p.writeConnect(connect)
 	return
 }
 
+var readNamePattern = regexp.MustCompile(`^(.+)-read[0-9]*$`)
+
+// 从库的名字是 连接名-read 或 连接名-read 加数字, 如 default-read, default-read1
+// 返回连接名, 不是从库时ok为false. default-readonly 这样的名字不是从库
+func readBase(name string) (connect string, ok bool) {
+	m := readNamePattern.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// name是否是connect本身或它的读写分离(-write, -read*)配置
+func isConnectName(name, connect string) bool {
+	if name == connect || name == connect+"-write" {
+		return true
+	}
+	base, ok := readBase(name)
+	return ok && base == connect
+}
+
+// 取得名为name的连接配置(通过RegisterDb或LoadConfig添加的)
+func GetConnect(name string) (connect Connect, ok bool) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	connect, ok = config[name]
+	return
+}
+
+func (p *Config) set(name string, connect Connect) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	(*p)[name] = connect
+}
+
 type Connect struct {
 	Driver string `json:"driver"`
 	// USER:PWD@tcp(HOST:PORT)/DBNAME
-	Url string `json:"url"`
+	Url  string `json:"url"`
+	Pool Pool   `json:"pool"`
+	// 从库的权重, 为0时是1
+	Weight int `json:"weight"`
+}
+
+// 没有设置Pool.MaxOpen时的最大连接数
+var DefaultMaxOpen = 2000
+
+// 连接池设置, 为0的项不设置(使用database/sql的默认值), MaxOpen为0时使用DefaultMaxOpen
+type Pool struct {
+	MaxOpen         int           `json:"max_open"`           // 最大打开的连接数
+	MaxIdle         int           `json:"max_idle"`           // 最大空闲连接数
+	ConnMaxLifetime time.Duration `json:"conn_max_lifetime"`  // 连接最长的存活时间
+	ConnMaxIdleTime time.Duration `json:"conn_max_idle_time"` // 连接最长的空闲时间
 }
 
 func (p *Connect) String() string {
@@ -50,3 +117,8 @@ func (p *Connect) String() string {
 func (p *Connect) SqlString() string {
 	return p.Url
 }
+
+// 根据Driver选择的sql方言
+func (p *Connect) Dialect() Dialect {
+	return GetDialect(p.Driver)
+}
@@ -0,0 +1,203 @@
+package orm
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"os"
+	"regexp"
+	"time"
+)
+
+// 配置文件中的一个连接, 时间可以是 "30s", "1h" 这样的字符串或者纳秒数
+// url与driver中的 ${ENV_VAR} 会被替换为环境变量
+type connectConfig struct {
+	Driver string     `json:"driver" yaml:"driver"`
+	Url    string     `json:"url" yaml:"url"`
+	Weight int        `json:"weight" yaml:"weight"`
+	Pool   poolConfig `json:"pool" yaml:"pool"`
+}
+
+type poolConfig struct {
+	MaxOpen         int         `json:"max_open" yaml:"max_open"`
+	MaxIdle         int         `json:"max_idle" yaml:"max_idle"`
+	ConnMaxLifetime interface{} `json:"conn_max_lifetime" yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime interface{} `json:"conn_max_idle_time" yaml:"conn_max_idle_time"`
+}
+
+// 配置有误, Connect是出错的连接名
+type ConfigError struct {
+	Connect string
+	Err     error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("orm: config %s: %v", e.Connect, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// 从文件中读取连接配置, 见LoadConfig
+func LoadConfigFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return LoadConfig(f)
+}
+
+// 读取JSON或YAML格式的连接配置, 以 { 开头的是JSON, 否则按YAML解析
+// 键是连接名, 同样支持 -write 与 -read* 后缀的读写分离配置:
+//
+//	default-write:
+//	  driver: mysql
+//	  url: root:${DB_PASSWORD}@tcp(master:3306)/test
+//	  pool: {max_open: 100, conn_max_lifetime: 1h}
+//	default-read1:
+//	  driver: mysql
+//	  url: root:${DB_PASSWORD}@tcp(slave1:3306)/test
+//	  weight: 2
+//
+// 所有连接都校验通过后才会写入配置, 有错误时返回*ConfigError
+func LoadConfig(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	raw := map[string]connectConfig{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		err = json.Unmarshal(data, &raw)
+	} else {
+		err = yaml.Unmarshal(data, &raw)
+	}
+	if err != nil {
+		return err
+	}
+
+	connects := make(map[string]Connect, len(raw))
+	for name, c := range raw {
+		connect, err := c.connect()
+		if err != nil {
+			return &ConfigError{Connect: name, Err: err}
+		}
+		connects[name] = connect
+	}
+	for name := range connects {
+		if err := checkMaster(name, connects); err != nil {
+			return &ConfigError{Connect: name, Err: err}
+		}
+	}
+
+	configLock.Lock()
+	defer configLock.Unlock()
+	for name, connect := range connects {
+		config[name] = connect
+	}
+	return nil
+}
+
+// 转换为Connect并校验
+func (p *connectConfig) connect() (c Connect, err error) {
+	c.Driver, err = expandEnv(p.Driver)
+	if err != nil {
+		return
+	}
+	c.Url, err = expandEnv(p.Url)
+	if err != nil {
+		return
+	}
+	c.Weight = p.Weight
+	c.Pool.MaxOpen = p.Pool.MaxOpen
+	c.Pool.MaxIdle = p.Pool.MaxIdle
+	c.Pool.ConnMaxLifetime, err = parseDuration(p.Pool.ConnMaxLifetime)
+	if err != nil {
+		err = fmt.Errorf("pool.conn_max_lifetime: %w", err)
+		return
+	}
+	c.Pool.ConnMaxIdleTime, err = parseDuration(p.Pool.ConnMaxIdleTime)
+	if err != nil {
+		err = fmt.Errorf("pool.conn_max_idle_time: %w", err)
+		return
+	}
+
+	switch {
+	case c.Driver == "":
+		err = fmt.Errorf("driver is empty")
+	case !driverRegistered(c.Driver):
+		err = fmt.Errorf("driver %q is not registered, forget import it?", c.Driver)
+	case c.Url == "":
+		err = fmt.Errorf("url is empty")
+	case c.Weight < 0:
+		err = fmt.Errorf("weight must not be negative")
+	case c.Pool.MaxOpen < 0 || c.Pool.MaxIdle < 0 || c.Pool.ConnMaxLifetime < 0 || c.Pool.ConnMaxIdleTime < 0:
+		err = fmt.Errorf("pool settings must not be negative")
+	}
+	return
+}
+
+// -read* 的连接需要有主库(连接本身或-write)用于写入
+func checkMaster(name string, connects map[string]Connect) error {
+	base, ok := readBase(name)
+	if !ok {
+		return nil
+	}
+	if _, ok := connects[base]; ok {
+		return nil
+	}
+	if _, ok := connects[base+"-write"]; ok {
+		return nil
+	}
+	if _, err := config.writeConnect(base); err == nil {
+		return nil
+	}
+	return fmt.Errorf("read connect has no master, add %q or %q", base, base+"-write")
+}
+
+func driverRegistered(driver string) bool {
+	for _, d := range sql.Drivers() {
+		if d == driver {
+			return true
+		}
+	}
+	return false
+}
+
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// 替换 ${ENV_VAR}, 环境变量没有设置时返回错误
+func expandEnv(s string) (string, error) {
+	var err error
+	s = envPattern.ReplaceAllStringFunc(s, func(m string) string {
+		name := m[2 : len(m)-1]
+		v, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v
+	})
+	return s, err
+}
+
+// 时间可以是 "30s" 这样的字符串, 或者纳秒数
+func parseDuration(v interface{}) (time.Duration, error) {
+	switch d := v.(type) {
+	case nil:
+		return 0, nil
+	case string:
+		return time.ParseDuration(d)
+	case int:
+		return time.Duration(d), nil
+	case int64:
+		return time.Duration(d), nil
+	case float64:
+		return time.Duration(d), nil
+	}
+	return 0, fmt.Errorf("invalid duration %v", v)
+}
@@ -0,0 +1,211 @@
+package orm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 方言, 处理不同数据库之间sql语法的差异
+// 根据Connect.Driver选择, 可以通过RegisterDialect扩展
+type Dialect interface {
+	Quote(name string) string       // 引用标识符, 如 `name` 或 "name"
+	Placeholder(index int) string   // 第index(从1开始)个参数的占位符, 如 ? 或 $1
+	Limit(offset, size int) string  // LIMIT子句
+	Returning(column string) string // INSERT时取回主键的子句, 不支持时返回"", 将使用LastInsertId
+	// 批量插入rows行后, 根据LastInsertId算出第一行的主键
+	FirstInsertId(lastInsertId int64, rows int) int64
+	// 插入冲突时更新的子句, conflict为冲突的列, update为要更新的列
+	Upsert(conflict, update []string) (string, error)
+}
+
+type MysqlDialect struct{}
+
+func (MysqlDialect) Quote(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+func (MysqlDialect) Placeholder(index int) string {
+	return "?"
+}
+func (MysqlDialect) Limit(offset, size int) string {
+	return fmt.Sprintf("LIMIT %d,%d", offset, size)
+}
+func (MysqlDialect) Returning(column string) string {
+	return ""
+}
+func (MysqlDialect) FirstInsertId(lastInsertId int64, rows int) int64 {
+	// mysql的LastInsertId就是第一行的id
+	return lastInsertId
+}
+func (p MysqlDialect) Upsert(conflict, update []string) (string, error) {
+	// mysql根据主键与唯一索引判断冲突, 不需要conflict
+	sets := make([]string, len(update))
+	for i, col := range update {
+		sets[i] = p.Quote(col) + "=VALUES(" + p.Quote(col) + ")"
+	}
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(sets, ","), nil
+}
+
+type PostgresDialect struct{}
+
+func (PostgresDialect) Quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+func (PostgresDialect) Placeholder(index int) string {
+	return "$" + strconv.Itoa(index)
+}
+func (PostgresDialect) Limit(offset, size int) string {
+	return fmt.Sprintf("LIMIT %d OFFSET %d", size, offset)
+}
+func (p PostgresDialect) Returning(column string) string {
+	return "RETURNING " + p.Quote(column)
+}
+func (PostgresDialect) FirstInsertId(lastInsertId int64, rows int) int64 {
+	return 0
+}
+func (p PostgresDialect) Upsert(conflict, update []string) (string, error) {
+	return onConflict(p, conflict, update)
+}
+
+type SqliteDialect struct{}
+
+func (SqliteDialect) Quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+func (SqliteDialect) Placeholder(index int) string {
+	return "?"
+}
+func (SqliteDialect) Limit(offset, size int) string {
+	return fmt.Sprintf("LIMIT %d OFFSET %d", size, offset)
+}
+func (SqliteDialect) Returning(column string) string {
+	return ""
+}
+func (SqliteDialect) FirstInsertId(lastInsertId int64, rows int) int64 {
+	// sqlite的LastInsertId是最后一行的id
+	return lastInsertId - int64(rows) + 1
+}
+func (p SqliteDialect) Upsert(conflict, update []string) (string, error) {
+	return onConflict(p, conflict, update)
+}
+
+// ON CONFLICT (conflict) DO UPDATE SET col=EXCLUDED.col
+func onConflict(d Dialect, conflict, update []string) (string, error) {
+	if len(conflict) == 0 {
+		return "", errors.New("ON CONFLICT need conflict columns, use OnConflict()")
+	}
+	cols := make([]string, len(conflict))
+	for i, col := range conflict {
+		cols[i] = d.Quote(col)
+	}
+	sets := make([]string, len(update))
+	for i, col := range update {
+		sets[i] = d.Quote(col) + "=EXCLUDED." + d.Quote(col)
+	}
+	return "ON CONFLICT (" + strings.Join(cols, ",") + ") DO UPDATE SET " + strings.Join(sets, ","), nil
+}
+
+// driver name => Dialect
+var dialects = map[string]Dialect{
+	"mysql":    MysqlDialect{},
+	"postgres": PostgresDialect{},
+	"pgx":      PostgresDialect{},
+	"sqlite3":  SqliteDialect{},
+	"sqlite":   SqliteDialect{},
+}
+
+var dialectLock sync.RWMutex
+
+// 为driver注册方言, 会覆盖已有的
+func RegisterDialect(driver string, dialect Dialect) {
+	dialectLock.Lock()
+	defer dialectLock.Unlock()
+	dialects[driver] = dialect
+}
+
+// 取得driver对应的方言, 未注册的driver使用mysql方言
+func GetDialect(driver string) Dialect {
+	dialectLock.RLock()
+	defer dialectLock.RUnlock()
+	if d, ok := dialects[driver]; ok {
+		return d
+	}
+	return MysqlDialect{}
+}
+
+// 引用一个可能带有前缀的名字, 如 db.table => `db`.`table`
+func quoteName(d Dialect, name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		if part == "*" {
+			continue
+		}
+		parts[i] = d.Quote(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// 拆分带别名的表名, 支持 "user u" 与 "user AS u"
+func splitTable(table string) (name, alias string) {
+	parts := strings.Fields(table)
+	switch {
+	case len(parts) == 3 && strings.EqualFold(parts[1], "as"):
+		return parts[0], parts[2]
+	case len(parts) == 2:
+		return parts[0], parts[1]
+	}
+	return table, ""
+}
+
+// 引用表名与别名, user u => `user` AS `u`
+func quoteTable(d Dialect, table string) string {
+	name, alias := splitTable(table)
+	if alias == "" {
+		return quoteName(d, name)
+	}
+	return quoteName(d, name) + " AS " + d.Quote(alias)
+}
+
+// 是否是 table.column 形式的列名
+func isQualifiedColumn(field string) bool {
+	i := strings.Index(field, ".")
+	if i <= 0 || i == len(field)-1 || field[i+1:] == "*" {
+		return false
+	}
+	for _, c := range field {
+		if !(c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// 将sql中的 ? 替换为方言的占位符, 会跳过引号中的 ?
+func rebind(d Dialect, sql string) string {
+	if d.Placeholder(1) == "?" {
+		return sql
+	}
+
+	var buf strings.Builder
+	index := 0
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			index++
+			buf.WriteString(d.Placeholder(index))
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String()
+}
@@ -0,0 +1,132 @@
+package orm
+
+import "testing"
+
+func TestDialect(t *testing.T) {
+	cases := []struct {
+		d           Dialect
+		quote       string // Quote(`a"b`+"`c")
+		placeholder string // Placeholder(3)
+		limit       string // Limit(10, 20)
+		returning   string // Returning("id")
+		firstId     int64  // FirstInsertId(10, 3)
+	}{
+		{MysqlDialect{}, "`a\"b``c`", "?", "LIMIT 10,20", "", 10},
+		{PostgresDialect{}, "\"a\"\"b`c\"", "$3", "LIMIT 20 OFFSET 10", `RETURNING "id"`, 0},
+		{SqliteDialect{}, "\"a\"\"b`c\"", "?", "LIMIT 20 OFFSET 10", "", 8},
+	}
+	for _, c := range cases {
+		if got := c.d.Quote("a\"b`c"); got != c.quote {
+			t.Errorf("%T Quote: got %q, want %q", c.d, got, c.quote)
+		}
+		if got := c.d.Placeholder(3); got != c.placeholder {
+			t.Errorf("%T Placeholder: got %q, want %q", c.d, got, c.placeholder)
+		}
+		if got := c.d.Limit(10, 20); got != c.limit {
+			t.Errorf("%T Limit: got %q, want %q", c.d, got, c.limit)
+		}
+		if got := c.d.Returning("id"); got != c.returning {
+			t.Errorf("%T Returning: got %q, want %q", c.d, got, c.returning)
+		}
+		if got := c.d.FirstInsertId(10, 3); got != c.firstId {
+			t.Errorf("%T FirstInsertId: got %d, want %d", c.d, got, c.firstId)
+		}
+	}
+}
+
+func TestDialectUpsert(t *testing.T) {
+	cases := []struct {
+		d    Dialect
+		want string
+	}{
+		{MysqlDialect{}, "ON DUPLICATE KEY UPDATE `name`=VALUES(`name`),`age`=VALUES(`age`)"},
+		{PostgresDialect{}, `ON CONFLICT ("id") DO UPDATE SET "name"=EXCLUDED."name","age"=EXCLUDED."age"`},
+		{SqliteDialect{}, `ON CONFLICT ("id") DO UPDATE SET "name"=EXCLUDED."name","age"=EXCLUDED."age"`},
+	}
+	for _, c := range cases {
+		got, err := c.d.Upsert([]string{"id"}, []string{"name", "age"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%T: got %q, want %q", c.d, got, c.want)
+		}
+	}
+	if _, err := (PostgresDialect{}).Upsert(nil, []string{"name"}); err == nil {
+		t.Error("postgres upsert without conflict columns should fail")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	cases := []struct {
+		d    Dialect
+		sql  string
+		want string
+	}{
+		{PostgresDialect{}, "a = ? AND b IN (?,?)", "a = $1 AND b IN ($2,$3)"},
+		// 引号中的 ? 不替换
+		{PostgresDialect{}, `a = '?' AND "b?" = ? AND c = ?`, `a = '?' AND "b?" = $1 AND c = $2`},
+		{PostgresDialect{}, "a = `?` AND b = ?", "a = `?` AND b = $1"},
+		{MysqlDialect{}, "a = ? AND b = '?'", "a = ? AND b = '?'"},
+		{SqliteDialect{}, "a = ?", "a = ?"},
+	}
+	for _, c := range cases {
+		if got := rebind(c.d, c.sql); got != c.want {
+			t.Errorf("%T rebind(%q): got %q, want %q", c.d, c.sql, got, c.want)
+		}
+	}
+}
+
+func TestQuoteName(t *testing.T) {
+	cases := []struct {
+		d     Dialect
+		name  string
+		table bool
+		want  string
+	}{
+		{MysqlDialect{}, "db.user", false, "`db`.`user`"},
+		{MysqlDialect{}, "u.*", false, "`u`.*"},
+		{PostgresDialect{}, "user u", true, `"user" AS "u"`},
+		{PostgresDialect{}, "user AS u", true, `"user" AS "u"`},
+		{SqliteDialect{}, "user", true, `"user"`},
+	}
+	for _, c := range cases {
+		got := quoteName(c.d, c.name)
+		if c.table {
+			got = quoteTable(c.d, c.name)
+		}
+		if got != c.want {
+			t.Errorf("%T %q: got %q, want %q", c.d, c.name, got, c.want)
+		}
+	}
+}
+
+func TestBuildInsertSqlReturning(t *testing.T) {
+	data := map[string]interface{}{"name": "a"}
+	cases := []struct {
+		d    Dialect
+		want string
+	}{
+		{MysqlDialect{}, "INSERT INTO `user` (`name` ) VALUES ( ? )"},
+		{PostgresDialect{}, `INSERT INTO "user" ("name" ) VALUES ( $1 ) RETURNING "id"`},
+		{SqliteDialect{}, `INSERT INTO "user" ("name" ) VALUES ( ? )`},
+	}
+	for _, c := range cases {
+		sql, _, err := buildInsertSql(c.d, "user", data, "id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sql != c.want {
+			t.Errorf("%T: got %q, want %q", c.d, sql, c.want)
+		}
+	}
+}
+
+func TestGetDialect(t *testing.T) {
+	if _, ok := GetDialect("pgx").(PostgresDialect); !ok {
+		t.Error("pgx should use postgres dialect")
+	}
+	if _, ok := GetDialect("unknown").(MysqlDialect); !ok {
+		t.Error("unknown driver should use mysql dialect")
+	}
+}
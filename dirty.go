@@ -0,0 +1,79 @@
+package orm
+
+import (
+	"github.com/bysir-zl/bygo/util"
+	"reflect"
+)
+
+// 嵌入到模型中开启脏字段跟踪
+// 通过Select读取的模型会记录各字段的原始值, Update时只更新值发生了变化的字段
+//
+//	type User struct {
+//		orm.Track
+//		...
+//	}
+type Track struct {
+	origin map[string]interface{} // 字段名 => 转换后(入库)的值
+}
+
+func (p *Track) ormTrack() *Track {
+	return p
+}
+
+type tracker interface {
+	ormTrack() *Track
+}
+
+// 记录模型当前的值, ptrModel可以是模型或模型slice的指针
+func (p *WithModel) snapshot(ptrModel interface{}) {
+	v := reflect.Indirect(reflect.ValueOf(ptrModel))
+	if v.Kind() != reflect.Slice {
+		if t, ok := ptrModel.(tracker); ok {
+			t.ormTrack().origin = p.trackData(ptrModel)
+		}
+		return
+	}
+
+	for i, l := 0, v.Len(); i < l; i++ {
+		item := v.Index(i)
+		if item.Kind() != reflect.Ptr {
+			item = item.Addr()
+		}
+		if item.IsNil() {
+			continue
+		}
+		if t, ok := item.Interface().(tracker); ok {
+			t.ormTrack().origin = p.trackData(item.Interface())
+		}
+	}
+}
+
+// 取得用于比较的值, 使用转换后的值, 这样tran(json)这类字段也能正确比较
+func (p *WithModel) trackData(ptrModel interface{}) map[string]interface{} {
+	data := map[string]interface{}{}
+	for k, v := range util.ObjToMap(ptrModel, "") {
+		if _, ok := p.modelInfo.FieldMap[k]; ok {
+			data[k] = v
+		}
+	}
+	// 转换失败的字段保持原值, 只会导致该字段总被认为发生了变化
+	lenient := p.lenient
+	p.lenient = true
+	p.tranSaveData(&data)
+	p.lenient = lenient
+	return data
+}
+
+// 取得模型记录的原始值, 没有跟踪时返回nil
+func origin(ptrModel interface{}) map[string]interface{} {
+	if t, ok := ptrModel.(tracker); ok {
+		return t.ormTrack().origin
+	}
+	return nil
+}
+
+// 值是否和原始值不同
+func changed(origin map[string]interface{}, field string, value interface{}) bool {
+	o, ok := origin[field]
+	return !ok || !reflect.DeepEqual(o, value)
+}
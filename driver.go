@@ -2,65 +2,188 @@ package orm
 
 import "database/sql"
 import (
+	"context"
+	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"sync"
 )
 
 type DbDriverMysql struct {
-	db *sql.DB
+	db  *sql.DB
+	tx  *sql.Tx // 不为nil时所有语句都在事务中执行
+	key string  // Connect.String(), 用于缓存预处理语句
+
+	dialect Dialect
 }
 
+// Connect.String() => *sql.DB
+// *sql.DB本身是一个连接池, 断开的连接由database/sql自动重连, 所以每个连接只打开一次
 var dbPoolMap = map[string]*sql.DB{}
-var dbPoolMapLock *sync.RWMutex = &sync.RWMutex{}
+var dbPoolMapLock sync.RWMutex
 
 // 单例取出db 并返回自己
 // err 是打开数据库连接的错误
 func Singleton(connect *Connect) (*DbDriverMysql, error) {
+	db, err := openDb(connect)
+	if err != nil {
+		return nil, err
+	}
+
+	dbDriverMysql := DbDriverMysql{}
+	dbDriverMysql.db = db
+	dbDriverMysql.key = connect.String()
+	dbDriverMysql.dialect = connect.Dialect()
+
+	return &dbDriverMysql, nil
+}
+
+// 取出连接对应的*sql.DB, 没有则打开一个
+func openDb(connect *Connect) (*sql.DB, error) {
 	configString := connect.String()
 
 	dbPoolMapLock.RLock()
-	db, isOk := dbPoolMap[configString]
+	db, ok := dbPoolMap[configString]
+	dbPoolMapLock.RUnlock()
+	if ok {
+		return db, nil
+	}
 
-	if !isOk {
-		dbPoolMapLock.RUnlock()
-		dbPoolMapLock.Lock()
-		_db, err := sql.Open(connect.Driver, connect.SqlString())
-		if err != nil {
-			return nil, err
+	dbPoolMapLock.Lock()
+	defer dbPoolMapLock.Unlock()
+	// 等待锁的时候可能已经被其他goroutine打开了
+	if db, ok = dbPoolMap[configString]; ok {
+		return db, nil
+	}
+	db, err := sql.Open(connect.Driver, connect.SqlString())
+	if err != nil {
+		return nil, err
+	}
+	applyPool(db, connect.Pool)
+	dbPoolMap[configString] = db
+	return db, nil
+}
+
+// 底层的*sql.DB
+func (p *DbDriverMysql) DB() *sql.DB {
+	return p.db
+}
+
+// 关闭connect及其读写分离(-read*, -write)的连接池, 用于优雅退出
+// 关闭后再次使用该连接会重新打开
+func Close(connect string) error {
+	configLock.RLock()
+	names := []string{}
+	for name, c := range config {
+		if isConnectName(name, connect) {
+			names = append(names, c.String())
 		}
-		_db.SetMaxOpenConns(2000)
+	}
+	configLock.RUnlock()
 
-		db = _db
-		dbPoolMap[configString] = db
-		dbPoolMapLock.Unlock()
-	} else {
-		err := db.Ping()
-		if err != nil {
-			// 如果ping不通,就删除这个连接,报错
-			delete(dbPoolMap, configString)
-			dbPoolMapLock.RUnlock()
-			return nil, err
+	return closeDb(names)
+}
+
+// 关闭所有打开的连接池
+func CloseAll() error {
+	dbPoolMapLock.RLock()
+	names := make([]string, 0, len(dbPoolMap))
+	for name := range dbPoolMap {
+		names = append(names, name)
+	}
+	dbPoolMapLock.RUnlock()
+
+	return closeDb(names)
+}
+
+// 关闭并移除连接池, 返回第一个错误
+func closeDb(names []string) (err error) {
+	dbs := []*sql.DB{}
+	dbPoolMapLock.Lock()
+	for _, name := range names {
+		if db, ok := dbPoolMap[name]; ok {
+			dbs = append(dbs, db)
+			delete(dbPoolMap, name)
 		}
-		dbPoolMapLock.RUnlock()
 	}
+	dbPoolMapLock.Unlock()
 
-	dbDriverMysql := DbDriverMysql{}
-	dbDriverMysql.db = db
+	for _, name := range names {
+		stmts.removeConnect(name)
+	}
 
-	return &dbDriverMysql, nil
+	for _, db := range dbs {
+		if e := db.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// 将连接池设置应用到db
+func applyPool(db *sql.DB, pool Pool) {
+	maxOpen := pool.MaxOpen
+	if maxOpen == 0 {
+		maxOpen = DefaultMaxOpen
+	}
+	db.SetMaxOpenConns(maxOpen)
+	if pool.MaxIdle != 0 {
+		db.SetMaxIdleConns(pool.MaxIdle)
+	}
+	if pool.ConnMaxLifetime != 0 {
+		db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	}
+	if pool.ConnMaxIdleTime != 0 {
+		db.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+	}
+}
+
+// 运行时修改连接池设置, 会修改connect及其读写分离(-read*, -write)的配置
+// 并应用到已经打开的*sql.DB上
+func SetPool(connect string, pool Pool) error {
+	configLock.Lock()
+	names := []string{}
+	for name, c := range config {
+		if isConnectName(name, connect) {
+			c.Pool = pool
+			config[name] = c
+			names = append(names, c.String())
+		}
+	}
+	configLock.Unlock()
+	if len(names) == 0 {
+		return fmt.Errorf("%w: %s", ErrConnectNotFound, connect)
+	}
+
+	dbPoolMapLock.RLock()
+	defer dbPoolMapLock.RUnlock()
+	for _, name := range names {
+		if db, ok := dbPoolMap[name]; ok {
+			applyPool(db, pool)
+		}
+	}
+	return nil
 }
 
 // 带返回值的查询,(读)
 // 返回一个[]map[string]interface 对应多行键值对
 func (p *DbDriverMysql) Query(sql string, args ...interface{}) (data []map[string]interface{}, err error) {
+	return p.QueryContext(context.Background(), sql, args...)
+}
+
+// 同Query, ctx取消或超时时会中断查询
+// 可识别的数据库错误会被转换为*DbError
+func (p *DbDriverMysql) QueryContext(ctx context.Context, sql string, args ...interface{}) (data []map[string]interface{}, err error) {
+	defer func() {
+		err = translateError(err)
+	}()
 	// SELECT
 
-	stmt, err := p.db.Prepare(sql)
+	stmt, release, err := p.prepare(ctx, sql)
 	if err != nil {
 		return
 	}
-	defer stmt.Close()
-	rows, err := stmt.Query(args...)
+	defer release()
+	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
 		return
 	}
@@ -95,6 +218,7 @@ func (p *DbDriverMysql) Query(sql string, args ...interface{}) (data []map[strin
 
 		data = append(data, st)
 	}
+	err = rows.Err()
 
 	return
 }
@@ -102,17 +226,25 @@ func (p *DbDriverMysql) Query(sql string, args ...interface{}) (data []map[strin
 // 执行不带返回的查询(写)
 // 返回insertId,
 func (p *DbDriverMysql) Exec(sql string, args ...interface{}) (affectCount int64, lastInsertId int64, err error) {
+	return p.ExecContext(context.Background(), sql, args...)
+}
+
+// 同Exec, ctx取消或超时时会中断执行
+// 可识别的数据库错误(如主键冲突, 死锁)会被转换为*DbError
+func (p *DbDriverMysql) ExecContext(ctx context.Context, sql string, args ...interface{}) (affectCount int64, lastInsertId int64, err error) {
+	defer func() {
+		err = translateError(err)
+	}()
 	affectCount = 0
 	lastInsertId = 0
 
-	stmt, err := p.db.Prepare(sql)
+	stmt, release, err := p.prepare(ctx, sql)
 	if err != nil {
 		return
 	}
 
-
-	defer stmt.Close()
-	result, err := stmt.Exec(args...)
+	defer release()
+	result, err := stmt.ExecContext(ctx, args...)
 
 	if err != nil {
 		return
@@ -126,3 +258,41 @@ func (p *DbDriverMysql) Exec(sql string, args ...interface{}) (affectCount int64
 
 	return
 }
+
+// 取得预处理语句, 优先使用缓存, 使用完后需要调用release
+// 事务中只在缓存命中时通过tx.StmtContext使用缓存的语句, 没命中时在事务的连接上预处理
+// 不能在连接池上预处理, 否则事务占着连接时可能等不到空闲连接而死锁
+func (p *DbDriverMysql) prepare(ctx context.Context, query string) (stmt *sql.Stmt, release func(), err error) {
+	if p.tx != nil {
+		if e, _ := stmts.lookup(p.key, query, false); e != nil {
+			txStmt := p.tx.StmtContext(ctx, e.stmt)
+			release = func() {
+				txStmt.Close()
+				stmts.release(e)
+			}
+			return txStmt, release, nil
+		}
+		stmt, err = p.tx.PrepareContext(ctx, query)
+		if err != nil {
+			return
+		}
+		release = func() { stmt.Close() }
+		return
+	}
+
+	e, err := stmts.get(ctx, p.db, p.key, query)
+	if err != nil {
+		return
+	}
+	if e == nil {
+		// 缓存已关闭
+		stmt, err = p.db.PrepareContext(ctx, query)
+		if err != nil {
+			return
+		}
+		release = func() { stmt.Close() }
+		return
+	}
+	release = func() { stmts.release(e) }
+	return e.stmt, release, nil
+}
@@ -0,0 +1,66 @@
+package orm
+
+import (
+	"errors"
+	"github.com/go-sql-driver/mysql"
+)
+
+// 可以通过errors.Is判断的错误
+var (
+	// 没有找到满足条件的行
+	ErrNoRows = errors.New("orm: no rows in result set")
+	// 模型没有通过RegisterModel注册
+	ErrModelNotRegistered = errors.New("orm: model not registered")
+	// 没有通过RegisterDb配置的连接
+	ErrConnectNotFound = errors.New("orm: connect not found")
+	// UPDATE/DELETE没有where条件
+	ErrNoWhere = errors.New("orm: no where condition")
+	// 违反主键或唯一索引
+	ErrDuplicateKey = errors.New("orm: duplicate key")
+	// 死锁, 事务已被回滚, 可以重试
+	ErrDeadlock = errors.New("orm: deadlock")
+	// 使用version字段更新时, 数据已经被其他人修改(version不匹配)
+	ErrStaleObject = errors.New("orm: stale object, the row has been modified or deleted")
+	// 没有注册的转换器
+	ErrTranslatorNotFound = errors.New("orm: translator not found, forget register it?")
+)
+
+// 数据库返回的错误
+// errors.Is(err, Kind)成立, 并且可以通过errors.As取得驱动的原始错误, 如*mysql.MySQLError
+type DbError struct {
+	Kind error
+	Err  error
+}
+
+func (e *DbError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DbError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DbError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// mysql错误号 => 错误类型
+var mysqlErrors = map[uint16]error{
+	1062: ErrDuplicateKey, // ER_DUP_ENTRY
+	1586: ErrDuplicateKey, // ER_DUP_ENTRY_WITH_KEY_NAME
+	1213: ErrDeadlock,     // ER_LOCK_DEADLOCK
+}
+
+// 将驱动返回的错误转换为*DbError, 不认识的错误原样返回
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		if kind, ok := mysqlErrors[me.Number]; ok {
+			return &DbError{Kind: kind, Err: err}
+		}
+	}
+	return err
+}
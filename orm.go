@@ -3,12 +3,15 @@ package orm
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 )
 
 var (
 	Debug = false
+	// 为true时转换与赋值出错只打印警告而不返回错误(旧的行为), 也可以通过WithModel.Lenient()单独开启
+	Lenient = false
 )
 
 type ModelInfo struct {
@@ -17,6 +20,11 @@ type ModelInfo struct {
 	Table       string                  // table name
 	ConnectName string                  // connect name
 	AutoPk      string                  // 自增主键
+	Pks         []string                // 主键字段, 按在struct中的顺序, 多个时为联合主键
+	SoftDelete  string                  // 软删除字段
+	Version     string                  // 乐观锁的版本字段
+	Defaults    map[string]string       // Insert时字段为空值时使用的值
+	OmitEmpty   map[string]bool         // Update时字段为空值则不写入
 	AutoFields  map[string]Auto
 	Trans       map[string]Tran
 	Links       map[string]Link
@@ -81,6 +89,11 @@ var DefaultDecoder = func(prtModel interface{}) ModelInfo {
 
 	field2Db := map[string]string{}
 	autoPk := ""
+	pks := []string{}
+	softDelete := ""
+	version := ""
+	defaults := map[string]string{}
+	omitEmpty := map[string]bool{}
 	autoFields := map[string]Auto{}
 	trans := map[string]Tran{}
 	links := map[string]Link{}
@@ -89,6 +102,8 @@ var DefaultDecoder = func(prtModel interface{}) ModelInfo {
 		for key, values := range columnTags {
 			switch key {
 			case "pk":
+				// pk 或 pk(auto)
+				pks = append(pks, field)
 				if len(values) >= 1 {
 					if values[0] == "auto" {
 						autoPk = field
@@ -98,6 +113,16 @@ var DefaultDecoder = func(prtModel interface{}) ModelInfo {
 				if len(values) >= 1 {
 					field2Db[field] = values[0]
 				}
+			case "soft_delete":
+				softDelete = field
+			case "version":
+				version = field
+			case "default":
+				if len(values) >= 1 {
+					defaults[field] = values[0]
+				}
+			case "omitempty":
+				omitEmpty[field] = true
 			case "tran":
 				if len(values) >= 1 {
 					trans[field] = Tran{
@@ -122,9 +147,18 @@ var DefaultDecoder = func(prtModel interface{}) ModelInfo {
 		}
 	}
 
+	sort.Slice(pks, func(i, j int) bool {
+		return fInfo[pks[i]].Index < fInfo[pks[j]].Index
+	})
+
 	m := ModelInfo{
 		FieldMap:    field2Db,
 		AutoPk:      autoPk,
+		Pks:         pks,
+		SoftDelete:  softDelete,
+		Version:     version,
+		Defaults:    defaults,
+		OmitEmpty:   omitEmpty,
 		Table:       table,
 		ConnectName: connect,
 		AutoFields:  autoFields,
@@ -149,14 +183,26 @@ func RegisterModelCustom(prtModel interface{}, decoder func(prtModel interface{}
 }
 
 // default,mysql,xxx:xxx
-func RegisterDb(connect, driver, link string) {
-	config[connect] = Connect{Url: link, Driver: driver}
+// pool可选, 设置连接池, 在第一次使用该连接打开*sql.DB时生效
+func RegisterDb(connect, driver, link string, pool ...Pool) {
+	c := Connect{Url: link, Driver: driver}
+	if len(pool) != 0 {
+		c.Pool = pool[0]
+	}
+	config.set(connect, c)
 }
 
 var translators = map[string]Translator{}
 
 var tranLock sync.RWMutex
 
+func getTranslator(name string) (translator Translator, ok bool) {
+	tranLock.RLock()
+	defer tranLock.RUnlock()
+	translator, ok = translators[name]
+	return
+}
+
 func RegisterTranslator(name string, translator Translator) {
 	tranLock.Lock()
 	defer tranLock.Unlock()
@@ -0,0 +1,79 @@
+package orm
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// 从库连接出错被剔除后, 多久之后再次尝试使用
+var ReplicaRetryInterval = 30 * time.Second
+
+var replicaLock sync.Mutex
+
+// connect => 下一次轮询的序号
+var replicaNext = map[string]uint64{}
+
+// Connect.String() => 可以再次使用的时间
+var replicaDown = map[string]time.Time{}
+
+// 按权重轮询选出一个可用的从库, 没有时返回nil
+func pickReplica(connect string, replicas []Connect) *Connect {
+	if len(replicas) == 0 {
+		return nil
+	}
+	now := time.Now()
+
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+	alive := []Connect{}
+	total := 0
+	for _, r := range replicas {
+		if until, ok := replicaDown[r.String()]; ok {
+			if now.Before(until) {
+				continue
+			}
+			delete(replicaDown, r.String())
+		}
+		alive = append(alive, r)
+		total += replicaWeight(r)
+	}
+	if total == 0 {
+		return nil
+	}
+
+	n := int(replicaNext[connect] % uint64(total))
+	replicaNext[connect]++
+	for i := range alive {
+		n -= replicaWeight(alive[i])
+		if n < 0 {
+			return &alive[i]
+		}
+	}
+	return nil
+}
+
+func replicaWeight(c Connect) int {
+	if c.Weight <= 0 {
+		return 1
+	}
+	return c.Weight
+}
+
+// 剔除从库, ReplicaRetryInterval后恢复
+func ejectReplica(c *Connect) {
+	replicaLock.Lock()
+	defer replicaLock.Unlock()
+	replicaDown[c.String()] = time.Now().Add(ReplicaRetryInterval)
+}
+
+// 是否是连接不上数据库的错误
+func isConnError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
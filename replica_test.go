@@ -0,0 +1,133 @@
+package orm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadBase(t *testing.T) {
+	cases := []struct {
+		name    string
+		connect string
+		ok      bool
+	}{
+		{"default-read", "default", true},
+		{"default-read1", "default", true},
+		{"default-read12", "default", true},
+		{"db-read-read2", "db-read", true},
+		{"default", "", false},
+		{"default-write", "", false},
+		{"default-readonly", "", false},
+		{"default-read1a", "", false},
+		{"-read1", "", false},
+	}
+	for _, c := range cases {
+		connect, ok := readBase(c.name)
+		if connect != c.connect || ok != c.ok {
+			t.Errorf("readBase(%q) = %q, %v, want %q, %v", c.name, connect, ok, c.connect, c.ok)
+		}
+	}
+
+	if isConnectName("default-readonly", "default") {
+		t.Error("default-readonly should not belong to default")
+	}
+	if !isConnectName("default-read2", "default") {
+		t.Error("default-read2 should belong to default")
+	}
+}
+
+// 统计轮询n次每个从库被选中的次数
+func pickCount(t *testing.T, connect string, replicas []Connect, n int) map[string]int {
+	count := map[string]int{}
+	for i := 0; i < n; i++ {
+		c := pickReplica(connect, replicas)
+		if c == nil {
+			t.Fatal("no replica picked")
+		}
+		count[c.Url]++
+	}
+	return count
+}
+
+func TestPickReplicaWeight(t *testing.T) {
+	cases := []struct {
+		name     string
+		replicas []Connect
+		want     map[string]int
+	}{
+		{"none", nil, map[string]int{}},
+		{"single", []Connect{{Url: "a"}}, map[string]int{"a": 12}},
+		{"equal", []Connect{{Url: "a"}, {Url: "b", Weight: 1}}, map[string]int{"a": 6, "b": 6}},
+		{"weighted", []Connect{{Url: "a", Weight: 2}, {Url: "b"}, {Url: "c", Weight: 3}}, map[string]int{"a": 4, "b": 2, "c": 6}},
+	}
+	for _, c := range cases {
+		connect := "test-weight-" + c.name
+		if len(c.replicas) == 0 {
+			if pickReplica(connect, c.replicas) != nil {
+				t.Errorf("%s: want nil", c.name)
+			}
+			continue
+		}
+		got := pickCount(t, connect, c.replicas, 12)
+		for url, n := range c.want {
+			if got[url] != n {
+				t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestEjectReplica(t *testing.T) {
+	connect := "test-eject"
+	replicas := []Connect{{Url: "eject-a"}, {Url: "eject-b"}}
+	defer func() {
+		replicaLock.Lock()
+		for i := range replicas {
+			delete(replicaDown, replicas[i].String())
+		}
+		replicaLock.Unlock()
+	}()
+
+	// 被剔除的从库不会被选中
+	ejectReplica(&replicas[1])
+	if got := pickCount(t, connect, replicas, 4); got["eject-a"] != 4 {
+		t.Errorf("ejected replica picked: %v", got)
+	}
+
+	// 都被剔除时没有可用的从库
+	ejectReplica(&replicas[0])
+	if c := pickReplica(connect, replicas); c != nil {
+		t.Errorf("all replicas ejected, got %v", c.Url)
+	}
+
+	// 超过ReplicaRetryInterval后恢复
+	replicaLock.Lock()
+	replicaDown[replicas[1].String()] = time.Now().Add(-time.Second)
+	replicaLock.Unlock()
+	if got := pickCount(t, connect, replicas, 2); got["eject-b"] != 2 {
+		t.Errorf("replica not recovered: %v", got)
+	}
+	replicaLock.Lock()
+	_, down := replicaDown[replicas[1].String()]
+	replicaLock.Unlock()
+	if down {
+		t.Error("recovered replica should be removed from replicaDown")
+	}
+}
+
+func TestEjectReplicaInterval(t *testing.T) {
+	old := ReplicaRetryInterval
+	ReplicaRetryInterval = 20 * time.Millisecond
+	defer func() { ReplicaRetryInterval = old }()
+
+	replicas := []Connect{{Url: "interval-a"}}
+	ejectReplica(&replicas[0])
+	if c := pickReplica("test-interval", replicas); c != nil {
+		t.Fatal("ejected replica picked")
+	}
+	time.Sleep(2 * ReplicaRetryInterval)
+	if c := pickReplica("test-interval", replicas); c == nil {
+		t.Error("replica not recovered after ReplicaRetryInterval")
+	}
+}
@@ -0,0 +1,134 @@
+package orm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 软删除
+// 在字段上使用 `orm:"col(deleted_at);soft_delete"` 后,
+// Select/Count/Update等操作会自动过滤已删除的行, Delete会变成将该列设置为当前时间的UPDATE
+
+const (
+	trashedWithout = iota // 默认, 不包含已删除的行
+	trashedWith           // 包含已删除的行
+	trashedOnly           // 只有已删除的行
+)
+
+// 查询时包含已删除的行
+func (p *WithModel) WithTrashed() *WithModel {
+	p.trashed = trashedWith
+	return p
+}
+
+// 只查询已删除的行
+func (p *WithModel) OnlyTrashed() *WithModel {
+	p.trashed = trashedOnly
+	return p
+}
+
+// 删除, 模型有soft_delete字段时只标记为删除
+func (p *WithModel) Delete() (affect int64, err error) {
+	if p.err != nil {
+		err = p.err
+		return
+	}
+	field := p.modelInfo.SoftDelete
+	if field == "" {
+		return p.WithOutModel.Delete()
+	}
+	if p.where.empty() {
+		err = fmt.Errorf("%w when DELETE", ErrNoWhere)
+		return
+	}
+
+	p.scope()
+	p.fields = nil
+	data := map[string]interface{}{field: timeValue(p.modelInfo.FieldTyp[field].String())}
+	err = p.tranSaveData(&data)
+	if err != nil {
+		return
+	}
+	return p.WithOutModel.Update(map[string]interface{}{p.modelInfo.FieldMap[field]: data[field]})
+}
+
+// 真正的删除, 不论是否已经被软删除
+func (p *WithModel) ForceDelete() (affect int64, err error) {
+	return p.WithOutModel.Delete()
+}
+
+// 恢复已删除的行
+func (p *WithModel) Restore() (affect int64, err error) {
+	if p.err != nil {
+		err = p.err
+		return
+	}
+	field := p.modelInfo.SoftDelete
+	if field == "" {
+		err = errors.New("model has no soft_delete field")
+		return
+	}
+
+	if p.where.empty() {
+		err = fmt.Errorf("%w when RESTORE", ErrNoWhere)
+		return
+	}
+
+	p.trashed = trashedOnly
+	p.scope()
+	p.fields = nil
+	var value interface{}
+	if p.softDeleteIsInt() {
+		value = 0
+	}
+	return p.WithOutModel.Update(map[string]interface{}{p.modelInfo.FieldMap[field]: value})
+}
+
+func (p *WithModel) softDeleteIsInt() bool {
+	return strings.Contains(p.modelInfo.FieldTyp[p.modelInfo.SoftDelete].String(), "int")
+}
+
+// 添加软删除的条件, 多次调用只会添加一次
+// 连表查询时同时给条件中本表的列加上表名前缀
+// int类型的字段用0表示未删除, 其他类型用NULL表示未删除
+func (p *WithModel) scope() {
+	// 连表时本表的列加上表名(或别名)前缀, 防止与连接的表的列重名
+	if len(p.joins) != 0 {
+		p.where.table = p.tableAlias()
+	}
+
+	field := p.modelInfo.SoftDelete
+	if field == "" || p.scoped || p.trashed == trashedWith {
+		return
+	}
+	p.scoped = true
+
+	// 原有条件中有OR时用括号包裹, 保证软删除的条件对所有行生效
+	p.where.wrapOr()
+
+	if p.trashed == trashedOnly {
+		if p.softDeleteIsInt() {
+			p.where.WhereGt(field, 0)
+		} else {
+			p.where.WhereNotNull(field)
+		}
+	} else {
+		if p.softDeleteIsInt() {
+			p.where.WhereGroup(func(c *Cond) {
+				c.WhereNull(field).add(condItem{Or: true, Column: c.column(field), Condition: "= 0"})
+			})
+		} else {
+			p.where.WhereNull(field)
+		}
+	}
+}
+
+// 字段类型为int时返回时间戳, 否则返回时间字符串
+func timeValue(typ string) interface{} {
+	if strings.Contains(typ, "int") {
+		return time.Now().Unix()
+	}
+	return time.Now().Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,161 @@
+package orm
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+)
+
+// 默认缓存的预处理语句个数, 运行时修改请使用SetStmtCache
+const DefaultStmtCacheSize = 1000
+
+// 预处理语句缓存, 以 连接+sql 为key, 超出容量时淘汰最久未使用的
+// 被淘汰的语句在没有被使用时才会关闭
+type stmtCache struct {
+	lock    sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+	hits    int64
+	misses  int64
+}
+
+type stmtEntry struct {
+	key     string
+	stmt    *sql.Stmt
+	refs    int  // 正在使用的次数
+	evicted bool // 已经被移出缓存, refs为0时关闭
+}
+
+var stmts = &stmtCache{
+	size:    DefaultStmtCacheSize,
+	entries: map[string]*list.Element{},
+	lru:     list.New(),
+}
+
+// 缓存命中统计
+type StmtStats struct {
+	Hits   int64
+	Misses int64
+	Size   int // 当前缓存的语句个数
+}
+
+// 设置预处理语句缓存的容量, size为0时关闭缓存(如使用ProxySQL等不支持预处理的代理时)
+func SetStmtCache(size int) {
+	if size < 0 {
+		size = 0
+	}
+	stmts.lock.Lock()
+	defer stmts.lock.Unlock()
+	stmts.size = size
+	stmts.shrink()
+}
+
+// 取得预处理语句缓存的命中统计
+func StmtCacheStats() StmtStats {
+	stmts.lock.Lock()
+	defer stmts.lock.Unlock()
+	return StmtStats{Hits: stmts.hits, Misses: stmts.misses, Size: stmts.lru.Len()}
+}
+
+func stmtKey(connect, query string) string {
+	return connect + "\x00" + query
+}
+
+// 在缓存中查找语句, 找到时引用计数加1, 使用完后需要调用release
+// enabled为false表示缓存已关闭. miss为true时没找到会计入未命中, 事务中只借用缓存不会预处理, 不计入
+func (p *stmtCache) lookup(connect, query string, miss bool) (e *stmtEntry, enabled bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.size == 0 {
+		return nil, false
+	}
+	if el, ok := p.entries[stmtKey(connect, query)]; ok {
+		p.hits++
+		p.lru.MoveToFront(el)
+		e = el.Value.(*stmtEntry)
+		e.refs++
+		return e, true
+	}
+	if miss {
+		p.misses++
+	}
+	return nil, true
+}
+
+// 取出或在db上预处理一条语句, 使用完后需要调用release
+// 缓存关闭时返回nil, 由调用方自己预处理
+func (p *stmtCache) get(ctx context.Context, db *sql.DB, connect, query string) (*stmtEntry, error) {
+	e, enabled := p.lookup(connect, query, true)
+	if !enabled || e != nil {
+		return e, nil
+	}
+	key := stmtKey(connect, query)
+
+	// 预处理可能较慢, 不在锁中进行
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	// 预处理时其他goroutine可能已经缓存了同样的语句
+	if el, ok := p.entries[key]; ok {
+		stmt.Close()
+		p.lru.MoveToFront(el)
+		e = el.Value.(*stmtEntry)
+		e.refs++
+		return e, nil
+	}
+	e = &stmtEntry{key: key, stmt: stmt, refs: 1}
+	if p.size == 0 {
+		// 预处理时缓存被关闭了
+		e.evicted = true
+		return e, nil
+	}
+	p.entries[key] = p.lru.PushFront(e)
+	p.shrink()
+	return e, nil
+}
+
+func (p *stmtCache) release(e *stmtEntry) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	e.refs--
+	if e.evicted && e.refs == 0 {
+		e.stmt.Close()
+	}
+}
+
+// 淘汰超出容量的语句, 需要持有锁
+func (p *stmtCache) shrink() {
+	for p.lru.Len() > p.size {
+		p.remove(p.lru.Back())
+	}
+}
+
+// 移除一条语句, 需要持有锁
+func (p *stmtCache) remove(el *list.Element) {
+	e := el.Value.(*stmtEntry)
+	p.lru.Remove(el)
+	delete(p.entries, e.key)
+	e.evicted = true
+	if e.refs == 0 {
+		e.stmt.Close()
+	}
+}
+
+// 移除连接的所有语句, 在关闭连接池时调用
+func (p *stmtCache) removeConnect(connect string) {
+	prefix := stmtKey(connect, "")
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for key, el := range p.entries {
+		if strings.HasPrefix(key, prefix) {
+			p.remove(el)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package orm
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+// 只支持Exec的假驱动, 用于测试预处理语句的缓存
+type cacheDriver struct{}
+
+func (cacheDriver) Open(name string) (driver.Conn, error) { return cacheConn{}, nil }
+
+type cacheConn struct{}
+
+func (cacheConn) Prepare(query string) (driver.Stmt, error) { return cacheStmt{}, nil }
+func (cacheConn) Close() error                              { return nil }
+func (cacheConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+type cacheStmt struct{}
+
+func (cacheStmt) Close() error  { return nil }
+func (cacheStmt) NumInput() int { return -1 }
+func (cacheStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+func (cacheStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func init() {
+	sql.Register("ormcache", cacheDriver{})
+}
+
+func newTestCache(t *testing.T, size int) (*stmtCache, *sql.DB) {
+	db, err := sql.Open("ormcache", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &stmtCache{size: size, entries: map[string]*list.Element{}, lru: list.New()}, db
+}
+
+// 取出语句后立即释放
+func cacheUse(t *testing.T, c *stmtCache, db *sql.DB, query string) *stmtEntry {
+	e, err := c.get(context.Background(), db, "test", query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.release(e)
+	return e
+}
+
+func stmtClosed(e *stmtEntry) bool {
+	_, err := e.stmt.Exec()
+	return err != nil
+}
+
+func TestStmtCacheEvict(t *testing.T) {
+	c, db := newTestCache(t, 2)
+	e1 := cacheUse(t, c, db, "q1")
+	e2 := cacheUse(t, c, db, "q2")
+	// q1命中后成为最近使用的, 之后淘汰的是q2
+	if e := cacheUse(t, c, db, "q1"); e != e1 {
+		t.Error("q1 should hit the cache")
+	}
+	e3 := cacheUse(t, c, db, "q3")
+
+	if _, ok := c.entries[stmtKey("test", "q2")]; ok {
+		t.Error("q2 should be evicted")
+	}
+	if !stmtClosed(e2) {
+		t.Error("evicted q2 should be closed")
+	}
+	if stmtClosed(e1) || stmtClosed(e3) {
+		t.Error("cached statements should not be closed")
+	}
+	if c.lru.Len() != 2 {
+		t.Errorf("size %d, want 2", c.lru.Len())
+	}
+	if c.hits != 1 || c.misses != 3 {
+		t.Errorf("hits %d misses %d, want 1 3", c.hits, c.misses)
+	}
+}
+
+// 正在使用的语句被淘汰时, 释放后才关闭
+func TestStmtCacheEvictInUse(t *testing.T) {
+	c, db := newTestCache(t, 1)
+	e1, err := c.get(context.Background(), db, "test", "q1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheUse(t, c, db, "q2")
+
+	if !e1.evicted {
+		t.Error("q1 should be evicted")
+	}
+	if stmtClosed(e1) {
+		t.Fatal("in-use statement should not be closed")
+	}
+	c.release(e1)
+	if !stmtClosed(e1) {
+		t.Error("statement should be closed after release")
+	}
+}
+
+func TestStmtCacheLookup(t *testing.T) {
+	c, db := newTestCache(t, 2)
+	// 事务中查找不到时不计入未命中
+	if e, enabled := c.lookup("test", "q1", false); e != nil || !enabled {
+		t.Errorf("got %v %v", e, enabled)
+	}
+	if c.misses != 0 {
+		t.Errorf("misses %d, want 0", c.misses)
+	}
+
+	cacheUse(t, c, db, "q1")
+	e, _ := c.lookup("test", "q1", false)
+	if e == nil {
+		t.Fatal("q1 should be found")
+	}
+	c.release(e)
+	if c.hits != 1 || c.misses != 1 {
+		t.Errorf("hits %d misses %d, want 1 1", c.hits, c.misses)
+	}
+}
+
+func TestStmtCacheDisabled(t *testing.T) {
+	c, db := newTestCache(t, 0)
+	e, err := c.get(context.Background(), db, "test", "q1")
+	if err != nil || e != nil {
+		t.Errorf("got %v %v, want nil", e, err)
+	}
+	if c.hits != 0 || c.misses != 0 || c.lru.Len() != 0 {
+		t.Errorf("disabled cache should not count, hits %d misses %d", c.hits, c.misses)
+	}
+}
+
+func TestStmtCacheRemoveConnect(t *testing.T) {
+	c, db := newTestCache(t, 3)
+	e1 := cacheUse(t, c, db, "q1")
+	e2, err := c.get(context.Background(), db, "other", "q1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.release(e2)
+
+	c.removeConnect("test")
+	if !stmtClosed(e1) || stmtClosed(e2) {
+		t.Error("only statements of the removed connect should be closed")
+	}
+	if c.lru.Len() != 1 {
+		t.Errorf("size %d, want 1", c.lru.Len())
+	}
+}
@@ -0,0 +1,49 @@
+package tests
+
+import (
+	"database/sql/driver"
+	"github.com/bysir-zl/orm"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Fields不影响Count与Paginate统计的总数
+func TestPaginateCountAll(t *testing.T) {
+	fakeReset()
+	fakeRowsFunc = func(query string) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "COUNT") {
+			return []string{"result"}, [][]driver.Value{{int64(25)}}
+		}
+		return []string{"nickname"}, [][]driver.Value{{"a"}}
+	}
+	_, pg, err := orm.Table("user").Connect("fake").Fields("nickname AS name").Where("age > ?", 18).Paginate(2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pg.Total != 25 || pg.PageTotal != 3 || pg.Page != 2 {
+		t.Errorf("page %+v", pg)
+	}
+	want := []string{
+		"SELECT COUNT(*) as result FROM `user` WHERE (( age > ? )) ",
+		"SELECT nickname AS name FROM `user` WHERE ( age > ? ) LIMIT 10,10 ",
+	}
+	if log := fakeReset(); !reflect.DeepEqual(log, want) {
+		t.Errorf("got  %q\nwant %q", log, want)
+	}
+}
+
+func TestCountColumn(t *testing.T) {
+	fakeReset()
+	fakeRowsFunc = func(query string) ([]string, [][]driver.Value) {
+		return []string{"result"}, [][]driver.Value{{int64(3)}}
+	}
+	count, err := orm.Table("user").Connect("fake").CountColumn("nickname")
+	if err != nil || count != 3 {
+		t.Fatal(count, err)
+	}
+	want := []string{"SELECT COUNT(`nickname`) as result FROM `user` "}
+	if log := fakeReset(); !reflect.DeepEqual(log, want) {
+		t.Errorf("got  %q\nwant %q", log, want)
+	}
+}
@@ -0,0 +1,39 @@
+package tests
+
+import (
+	"github.com/bysir-zl/orm"
+	"reflect"
+	"testing"
+)
+
+type BUser struct {
+	orm string `table:"buser" connect:"fake" json:"-"`
+
+	Id   int    `orm:"col(id);pk(auto)"`
+	Name string `orm:"col(name)"`
+	Sex  bool   `orm:"col(sex)"`
+}
+
+func init() {
+	orm.RegisterModel(new(BUser))
+}
+
+func TestInsertBatch(t *testing.T) {
+	fakeReset()
+	us := []BUser{{Id: 5, Name: "a"}, {Name: "b", Sex: true}, {Name: "c"}}
+	if _, err := orm.Model(&us).InsertBatch(&us); err != nil {
+		t.Fatal(err)
+	}
+	// 指定了主键的行单独插入, 其他行的sex即使为false也写入, 合并到一条语句中
+	want := []string{
+		"INSERT INTO `buser` (`id`,`name`,`sex` ) VALUES ( ?,?,? )",
+		"INSERT INTO `buser` (`name`,`sex` ) VALUES ( ?,? ),( ?,? )",
+	}
+	if log := fakeReset(); !reflect.DeepEqual(log, want) {
+		t.Errorf("got  %q\nwant %q", log, want)
+	}
+	// 指定的主键不会被覆盖, fake驱动的LastInsertId总是100
+	if us[0].Id != 5 || us[1].Id != 100 || us[2].Id != 101 {
+		t.Errorf("ids %d %d %d", us[0].Id, us[1].Id, us[2].Id)
+	}
+}
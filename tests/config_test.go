@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"errors"
+	"github.com/bysir-zl/orm"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	os.Setenv("ORM_TEST_PWD", "secret")
+	err := orm.LoadConfig(strings.NewReader(`{
+		"cfg-write": {"driver": "mysql", "url": "root:${ORM_TEST_PWD}@tcp(127.0.0.1:3306)/test",
+			"pool": {"max_open": 10, "conn_max_lifetime": "1h", "conn_max_idle_time": 30000000000}},
+		"cfg-read1": {"driver": "mysql", "url": "root:${ORM_TEST_PWD}@tcp(127.0.0.2:3306)/test", "weight": 2}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLoadedConfig(t, "cfg")
+}
+
+func TestLoadConfigYaml(t *testing.T) {
+	os.Setenv("ORM_TEST_PWD", "secret")
+	err := orm.LoadConfig(strings.NewReader(`
+ycfg-write:
+  driver: mysql
+  url: root:${ORM_TEST_PWD}@tcp(127.0.0.1:3306)/test
+  pool:
+    max_open: 10
+    conn_max_lifetime: 1h
+    conn_max_idle_time: 30000000000
+ycfg-read1:
+  driver: mysql
+  url: root:${ORM_TEST_PWD}@tcp(127.0.0.2:3306)/test
+  weight: 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLoadedConfig(t, "ycfg")
+}
+
+func checkLoadedConfig(t *testing.T, name string) {
+	w, ok := orm.GetConnect(name + "-write")
+	if !ok {
+		t.Fatalf("%s-write not loaded", name)
+	}
+	want := orm.Connect{
+		Driver: "mysql",
+		Url:    "root:secret@tcp(127.0.0.1:3306)/test",
+		Pool:   orm.Pool{MaxOpen: 10, ConnMaxLifetime: time.Hour, ConnMaxIdleTime: 30 * time.Second},
+	}
+	if w != want {
+		t.Errorf("%s-write: got %+v, want %+v", name, w, want)
+	}
+
+	r, ok := orm.GetConnect(name + "-read1")
+	if !ok {
+		t.Fatalf("%s-read1 not loaded", name)
+	}
+	if r.Url != "root:secret@tcp(127.0.0.2:3306)/test" || r.Weight != 2 {
+		t.Errorf("%s-read1: got %+v", name, r)
+	}
+
+	// SetPool会修改name, name-write, name-read*, 找不到时返回ErrConnectNotFound
+	if err := orm.SetPool(name, orm.Pool{MaxOpen: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := orm.GetConnect(name + "-read1"); r.Pool.MaxOpen != 5 {
+		t.Errorf("SetPool did not update %s-read1: %+v", name, r.Pool)
+	}
+}
+
+func TestLoadConfigError(t *testing.T) {
+	os.Unsetenv("ORM_TEST_UNSET")
+	cases := map[string]string{
+		"bad-env":      `{"bad-env": {"driver": "mysql", "url": "root:${ORM_TEST_UNSET}@/test"}}`,
+		"bad-driver":   `{"bad-driver": {"driver": "nodriver", "url": "root@/test"}}`,
+		"bad-duration": `{"bad-duration": {"driver": "mysql", "url": "root@/test", "pool": {"conn_max_idle_time": "1x"}}}`,
+		"orphan-read":  `{"orphan-read": {"driver": "mysql", "url": "root@/test"}}`,
+	}
+	for name, data := range cases {
+		err := orm.LoadConfig(strings.NewReader(data))
+		var ce *orm.ConfigError
+		if !errors.As(err, &ce) || ce.Connect != name {
+			t.Errorf("%s: want ConfigError for %s, got %v", name, name, err)
+		}
+	}
+	if _, ok := orm.GetConnect("bad-env"); ok {
+		t.Error("invalid config should not be loaded")
+	}
+}
@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"github.com/bysir-zl/orm"
+	"io"
+	"sync"
+)
+
+// 不需要数据库的假驱动, 记录执行的sql
+// 查询默认返回空结果, 可以通过fakeRowsFunc指定
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return &fakeConn{}, nil
+}
+
+var fakeLock sync.Mutex
+var fakeLog []string
+
+// 根据sql返回查询结果, 为nil时返回空结果
+var fakeRowsFunc func(query string) (columns []string, rows [][]driver.Value)
+
+// 根据sql返回执行的错误, 为nil时都执行成功
+var fakeExecFunc func(query string) error
+
+// 清空记录, 返回之前记录的语句
+func fakeReset() []string {
+	fakeLock.Lock()
+	defer fakeLock.Unlock()
+	log := fakeLog
+	fakeLog = nil
+	fakeRowsFunc = nil
+	fakeExecFunc = nil
+	return log
+}
+
+func fakeRecord(s string) {
+	fakeLock.Lock()
+	defer fakeLock.Unlock()
+	fakeLog = append(fakeLog, s)
+}
+
+type fakeConn struct {
+	inTx bool
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	fakeRecord("BEGIN")
+	c.inTx = true
+	return &fakeTx{conn: c}, nil
+}
+
+type fakeTx struct {
+	conn *fakeConn
+}
+
+func (t *fakeTx) Commit() error {
+	fakeRecord("COMMIT")
+	t.conn.inTx = false
+	return nil
+}
+func (t *fakeTx) Rollback() error {
+	fakeRecord("ROLLBACK")
+	t.conn.inTx = false
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+// 事务中执行的语句记录为 "TX " + sql
+func (s *fakeStmt) record() {
+	if s.conn.inTx {
+		fakeRecord("TX " + s.query)
+	} else {
+		fakeRecord(s.query)
+	}
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.record()
+	fakeLock.Lock()
+	fn := fakeExecFunc
+	fakeLock.Unlock()
+	if fn != nil {
+		if err := fn(s.query); err != nil {
+			return nil, err
+		}
+	}
+	return fakeResult{}, nil
+}
+
+// 每条语句影响1行, LastInsertId为100
+type fakeResult struct{}
+
+func (fakeResult) LastInsertId() (int64, error) { return 100, nil }
+func (fakeResult) RowsAffected() (int64, error) { return 1, nil }
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.record()
+	fakeLock.Lock()
+	fn := fakeRowsFunc
+	fakeLock.Unlock()
+	if fn == nil {
+		return &fakeRows{columns: []string{"1"}}, nil
+	}
+	columns, rows := fn(s.query)
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func init() {
+	sql.Register("ormfake", fakeDriver{})
+	orm.RegisterDb("fake", "ormfake", "fake")
+}
@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"github.com/bysir-zl/orm"
+	"sync"
+	"testing"
+	"time"
+)
+
+// go test -race -run TestSingletonConcurrent
+// sql.Open不会连接数据库, 所以不需要真实的mysql
+func TestSingletonConcurrent(t *testing.T) {
+	orm.RegisterDb("pool_race", "mysql", "root:root@tcp(127.0.0.1:3306)/pool_race")
+	connect := &orm.Connect{Driver: "mysql", Url: "root:root@tcp(127.0.0.1:3306)/pool_race"}
+
+	const n = 50
+	drivers := make([]*orm.DbDriverMysql, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d, err := orm.Singleton(connect)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			drivers[i] = d
+			if i%10 == 0 {
+				orm.SetPool("pool_race", orm.Pool{MaxOpen: 10})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, d := range drivers {
+		if d == nil || d.DB() != drivers[0].DB() {
+			t.Fatal("Singleton opened more than one *sql.DB")
+		}
+	}
+
+	if err := orm.Close("pool_race"); err != nil {
+		t.Fatal(err)
+	}
+	d, err := orm.Singleton(connect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.DB() == drivers[0].DB() {
+		t.Fatal("Singleton returned a closed *sql.DB")
+	}
+	orm.CloseAll()
+}
+
+// 事务占着唯一的连接时, 预处理语句不能再向连接池要连接
+func TestTxStmtTinyPool(t *testing.T) {
+	const n = 3
+	orm.RegisterDb("tiny", "ormfake", "tiny", orm.Pool{MaxOpen: 1})
+	defer orm.Close("tiny")
+
+	// 先在事务外缓存语句, 事务中既有命中缓存的, 也有没命中的
+	if _, err := orm.Table("").Connect("tiny").QuerySql("SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			done <- orm.Transaction("tiny", func(tx *orm.Tx) error {
+				if _, err := tx.QuerySql("SELECT 1"); err != nil {
+					return err
+				}
+				_, err := tx.QuerySql("SELECT 2")
+				return err
+			})
+		}()
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("transaction blocked waiting for a pool connection")
+		}
+	}
+}
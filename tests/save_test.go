@@ -0,0 +1,28 @@
+package tests
+
+import (
+	"github.com/bysir-zl/orm"
+	"github.com/go-sql-driver/mysql"
+	"strings"
+	"testing"
+)
+
+// Exists之后被并发插入了同样主键的行时改为更新
+func TestSaveDuplicateRetry(t *testing.T) {
+	fakeReset()
+	fakeExecFunc = func(query string) error {
+		if strings.HasPrefix(query, "INSERT") {
+			return &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}
+		}
+		return nil
+	}
+	u := SUser{Id: 1, Name: "a"}
+	if err := orm.Model(&u).Save(&u); err != nil {
+		t.Fatal(err)
+	}
+	log := fakeReset()
+	if len(log) != 3 || !strings.HasPrefix(log[0], "SELECT") ||
+		!strings.HasPrefix(log[1], "INSERT") || !strings.HasPrefix(log[2], "UPDATE") {
+		t.Errorf("want SELECT, INSERT, UPDATE, got %q", log)
+	}
+}
@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"errors"
+	"github.com/bysir-zl/orm"
+	"reflect"
+	"testing"
+)
+
+type SUser struct {
+	orm string `table:"suser" connect:"fake" json:"-"`
+
+	Id        int    `orm:"col(id);pk(auto)"`
+	Name      string `orm:"col(name)"`
+	DeletedAt int64  `orm:"col(deleted_at);soft_delete"`
+}
+
+func init() {
+	orm.RegisterModel(new(SUser))
+}
+
+// 软删除的条件不能被当作where条件
+func TestSoftDeleteNoWhere(t *testing.T) {
+	fakeReset()
+	u := SUser{Id: 1, Name: "a"}
+	if _, err := orm.Model(&u).Update(&u); !errors.Is(err, orm.ErrNoWhere) {
+		t.Errorf("Update: want ErrNoWhere, got %v", err)
+	}
+	if _, err := orm.Model(&u).Restore(); !errors.Is(err, orm.ErrNoWhere) {
+		t.Errorf("Restore: want ErrNoWhere, got %v", err)
+	}
+	if _, err := orm.Model(&u).Delete(); !errors.Is(err, orm.ErrNoWhere) {
+		t.Errorf("Delete: want ErrNoWhere, got %v", err)
+	}
+	if log := fakeReset(); len(log) != 0 {
+		t.Errorf("no sql should be executed, got %v", log)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	fakeReset()
+	u := SUser{Id: 1, Name: "a"}
+	if _, err := orm.Model(&u).Where("id = ?", 1).Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orm.Model(&u).Where("id = ?", 1).OrWhere("id = ?", 2).Update(&u); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"UPDATE `suser` SET `deleted_at`=? WHERE ( id = ? ) AND ( `deleted_at` > ? ) ",
+		"UPDATE `suser` SET `id`=?,`name`=? WHERE ( ( id = ? ) OR ( id = ? ) ) AND ( ( `deleted_at` IS NULL ) OR ( `deleted_at` = 0 ) ) ",
+	}
+	if log := fakeReset(); !reflect.DeepEqual(log, want) {
+		t.Errorf("got  %q\nwant %q", log, want)
+	}
+}
+
+// 连表时本表的列要加上别名, 防止与连接的表的列重名
+func TestSoftDeleteJoin(t *testing.T) {
+	fakeReset()
+	var us []SUser
+	_, err := orm.Model(&us).Table("suser u").
+		LeftJoin("role r", "r.id = u.role_id").
+		WhereEq("Id", 1).OrWhereGroup(func(c *orm.Cond) {
+		c.WhereEq("r.id", 2)
+	}).Select(&us)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"SELECT `u`.`deleted_at` AS `deleted_at`,`u`.`id` AS `id`,`u`.`name` AS `name` FROM `suser` AS `u` LEFT JOIN `role` AS `r` ON r.id = u.role_id WHERE ( ( `u`.`id` = ? ) OR ( ( `r`.`id` = ? ) ) ) AND ( ( `u`.`deleted_at` IS NULL ) OR ( `u`.`deleted_at` = 0 ) ) ",
+	}
+	if log := fakeReset(); !reflect.DeepEqual(log, want) {
+		t.Errorf("got  %q\nwant %q", log, want)
+	}
+}
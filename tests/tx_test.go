@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"errors"
+	"github.com/bysir-zl/orm"
+	"reflect"
+	"testing"
+)
+
+// Model与Table创建的操作都在事务的连接上执行
+func TestTransactionCommit(t *testing.T) {
+	fakeReset()
+	err := orm.Transaction("fake", func(tx *orm.Tx) error {
+		u := SUser{Id: 1, Name: "a"}
+		if _, err := tx.Model(&u).WhereEq("Id", 1).Update(&u); err != nil {
+			return err
+		}
+		if _, err := tx.Table("log").Insert(map[string]interface{}{"msg": "a"}); err != nil {
+			return err
+		}
+		_, _, err := tx.ExecSql("DELETE FROM log WHERE id = ?", 1)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"BEGIN",
+		"TX UPDATE `suser` SET `id`=?,`name`=? WHERE ( `id` = ? ) AND ( ( `deleted_at` IS NULL ) OR ( `deleted_at` = 0 ) ) ",
+		"TX INSERT INTO `log` (`msg` ) VALUES ( ? )",
+		"TX DELETE FROM log WHERE id = ?",
+		"COMMIT",
+	}
+	if log := fakeReset(); !reflect.DeepEqual(log, want) {
+		t.Errorf("got  %q\nwant %q", log, want)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	fakeReset()
+	fnErr := errors.New("fn error")
+	err := orm.Transaction("fake", func(tx *orm.Tx) error {
+		if _, _, err := tx.ExecSql("DELETE FROM log WHERE id = ?", 1); err != nil {
+			return err
+		}
+		return fnErr
+	})
+	if err != fnErr {
+		t.Errorf("want fn error, got %v", err)
+	}
+	want := []string{"BEGIN", "TX DELETE FROM log WHERE id = ?", "ROLLBACK"}
+	if log := fakeReset(); !reflect.DeepEqual(log, want) {
+		t.Errorf("got  %q\nwant %q", log, want)
+	}
+}
+
+// panic时回滚并继续向上抛出
+func TestTransactionPanic(t *testing.T) {
+	fakeReset()
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("want panic boom, got %v", r)
+		}
+		want := []string{"BEGIN", "TX DELETE FROM log WHERE id = ?", "ROLLBACK"}
+		if log := fakeReset(); !reflect.DeepEqual(log, want) {
+			t.Errorf("got  %q\nwant %q", log, want)
+		}
+	}()
+	orm.Transaction("fake", func(tx *orm.Tx) error {
+		tx.ExecSql("DELETE FROM log WHERE id = ?", 1)
+		panic("boom")
+	})
+	t.Error("panic should be re-raised")
+}
@@ -0,0 +1,48 @@
+package tests
+
+import (
+	"errors"
+	"github.com/bysir-zl/orm"
+	"reflect"
+	"testing"
+)
+
+type VUser struct {
+	orm string `table:"vuser" connect:"fake" json:"-"`
+
+	Id      int    `orm:"col(id);pk(auto)"`
+	Name    string `orm:"col(name)"`
+	Version int    `orm:"col(version);version"`
+}
+
+func init() {
+	orm.RegisterModel(new(VUser))
+}
+
+// 乐观锁的条件不能被当作where条件
+func TestVersionNoWhere(t *testing.T) {
+	fakeReset()
+	u := VUser{Id: 1, Name: "a", Version: 3}
+	if _, err := orm.Model(&u).Update(&u); !errors.Is(err, orm.ErrNoWhere) {
+		t.Errorf("want ErrNoWhere, got %v", err)
+	}
+	if log := fakeReset(); len(log) != 0 {
+		t.Errorf("no sql should be executed, got %v", log)
+	}
+}
+
+// 原有条件中的OR不能绕过乐观锁
+func TestVersionOrWhere(t *testing.T) {
+	fakeReset()
+	u := VUser{Id: 1, Name: "a", Version: 3}
+	if _, err := orm.Model(&u).Where("id = ?", 1).OrWhere("name = ?", "a").Update(&u); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"UPDATE `vuser` SET `id`=?,`name`=?,`version`=? WHERE ( ( id = ? ) OR ( name = ? ) ) AND ( `version` = ? ) "}
+	if log := fakeReset(); !reflect.DeepEqual(log, want) {
+		t.Errorf("got  %q\nwant %q", log, want)
+	}
+	if u.Version != 4 {
+		t.Errorf("version should be 4, got %d", u.Version)
+	}
+}
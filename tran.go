@@ -3,12 +3,39 @@ package orm
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/bysir-zl/bygo/util"
 	"reflect"
 	"strings"
 	"time"
 )
 
+// 转换器出错
+type TranError struct {
+	Table string
+	Field string
+	Tran  string // 转换器名
+	Err   error
+}
+
+func (e *TranError) Error() string {
+	return fmt.Sprintf("table(%s) field %s tran(%s): %v", e.Table, e.Field, e.Tran, e.Err)
+}
+
+func (e *TranError) Unwrap() error {
+	return e.Err
+}
+
+// 将db的值赋值到struct时出错
+type AssignError struct {
+	Table string
+	Info  string
+}
+
+func (e *AssignError) Error() string {
+	return fmt.Sprintf("table(%s) assign: %s", e.Table, e.Info)
+}
+
 type Translator interface {
 	Input(fieldName string, fieldType reflect.Type, input interface{}) (interface{}, error) // 入库
 	Output(fieldName string, field reflect.Type, output interface{}) (interface{}, error)   // 出库
@@ -0,0 +1,106 @@
+package orm
+
+import "context"
+
+// 事务
+// 通过Model/Table创建的操作都会在同一个*sql.Tx上执行
+type Tx struct {
+	connect string
+	driver  *DbDriverMysql
+	ctx     context.Context
+}
+
+// 在指定连接上开启一个事务
+func Begin(connect string) (tx *Tx, err error) {
+	return BeginContext(context.Background(), connect)
+}
+
+// 同Begin, ctx在提交前被取消时事务会被回滚
+// 事务中的语句默认使用该ctx, 可以通过Ctx()单独指定
+func BeginContext(ctx context.Context, connect string) (tx *Tx, err error) {
+	c, err := config.writeConnect(connect)
+	if err != nil {
+		return
+	}
+	dbDriver, err := Singleton(c)
+	if err != nil {
+		return
+	}
+	sqlTx, err := dbDriver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	info("BEGIN", connect)
+
+	tx = &Tx{
+		connect: connect,
+		driver:  &DbDriverMysql{db: dbDriver.db, tx: sqlTx, key: dbDriver.key, dialect: dbDriver.dialect},
+		ctx:     ctx,
+	}
+	return
+}
+
+// 在事务中执行fn, fn返回error或panic时回滚, 否则提交
+// panic会在回滚后继续向上抛出
+func Transaction(connect string, fn func(tx *Tx) error) (err error) {
+	return TransactionContext(context.Background(), connect, fn)
+}
+
+func TransactionContext(ctx context.Context, connect string, fn func(tx *Tx) error) (err error) {
+	tx, err := BeginContext(ctx, connect)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			warn("rollback", e)
+		}
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+func (p *Tx) Commit() error {
+	info("COMMIT", p.connect)
+	return translateError(p.driver.tx.Commit())
+}
+
+func (p *Tx) Rollback() error {
+	info("ROLLBACK", p.connect)
+	return p.driver.tx.Rollback()
+}
+
+// 在事务中操作模型
+func (p *Tx) Model(mo interface{}) *WithModel {
+	w := newWithModel(mo)
+	w.tx = p
+	w.ctx = p.ctx
+	w.connect = p.connect
+	return w
+}
+
+// 在事务中操作表
+func (p *Tx) Table(table string) *WithOutModel {
+	w := newWithOutModel().Table(table)
+	w.tx = p
+	w.ctx = p.ctx
+	w.connect = p.connect
+	return w
+}
+
+func (p *Tx) ExecSql(sql string, args ...interface{}) (affectCount int64, lastInsertId int64, err error) {
+	return p.Table("").ExecSql(sql, args...)
+}
+
+func (p *Tx) QuerySql(sql string, args ...interface{}) (data []map[string]interface{}, err error) {
+	return p.Table("").QuerySql(sql, args...)
+}
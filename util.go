@@ -1,11 +1,14 @@
 package orm
 
 import (
+	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 )
 
 type FieldInfo struct {
+	Index        int    // 在struct中的位置
 	Name         string // 字段名
 	Typ          reflect.Type
 	CanInterface bool
@@ -21,6 +24,7 @@ func DecodeStruct(prtStruct interface{}) map[string]FieldInfo {
 		field := t.Field(i)
 		tags := EncodeTag(string(field.Tag))
 		result[field.Name] = FieldInfo{
+			Index:       i,
 			Typ:         field.Type,
 			CanInterface:v.Field(i).CanInterface(),
 			Name:        field.Name,
@@ -115,3 +119,50 @@ func UnDuplicate(src []interface{}) []interface{} {
 
 	return temp
 }
+
+// 将db中读出的值转换为int64, 如 COUNT(*) 的结果
+func toInt64(v interface{}) (int64, error) {
+	switch value := v.(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return value, nil
+	case int:
+		return int64(value), nil
+	case float64:
+		return int64(value), nil
+	case []byte:
+		return strconv.ParseInt(string(value), 10, 64)
+	case string:
+		return strconv.ParseInt(value, 10, 64)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), nil
+	}
+	return 0, fmt.Errorf("can't convert %T to int64", v)
+}
+
+// 将db中读出的值转换为float64, 如 SUM(), AVG() 的结果
+func toFloat64(v interface{}) (float64, error) {
+	switch value := v.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return value, nil
+	case float32:
+		return float64(value), nil
+	case int64:
+		return float64(value), nil
+	case int:
+		return float64(value), nil
+	case []byte:
+		return strconv.ParseFloat(string(value), 64)
+	case string:
+		return strconv.ParseFloat(value, 64)
+	}
+	return 0, fmt.Errorf("can't convert %T to float64", v)
+}
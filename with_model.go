@@ -1,13 +1,14 @@
 package orm
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/bysir-zl/bygo/log"
 	"github.com/bysir-zl/bygo/util"
 	"reflect"
+	"sort"
 	"strings"
-	"time"
 )
 
 type WithModel struct {
@@ -17,6 +18,14 @@ type WithModel struct {
 	link map[string]linkData // objFieldName => linkData
 
 	preLinkData map[InOneSql]PreLink
+
+	trashed int  // 软删除的查询范围
+	scoped  bool // 是否已经添加了软删除的条件
+
+	omit []string // Insert/Update时忽略的字段
+	must []string // Insert/Update时必须写入的字段
+
+	lenient bool // 转换出错时只打印警告
 }
 
 func newWithModel(ptrModel interface{}) *WithModel {
@@ -27,9 +36,11 @@ func newWithModel(ptrModel interface{}) *WithModel {
 	typ = strings.Replace(typ, "[]", "", -1)
 	mInfo, ok := modelInfo[typ]
 	if !ok {
-		w.err = errors.New("can't found model " + typ + ",forget register?")
+		w.err = fmt.Errorf("%w: %s, forget register?", ErrModelNotRegistered, typ)
 	} else {
 		w.modelInfo = mInfo
+		w.pk = mInfo.FieldMap[mInfo.AutoPk]
+		w.where.columns = mInfo.FieldMap
 	}
 	w.table = w.modelInfo.Table
 	w.connect = w.modelInfo.ConnectName
@@ -46,6 +57,17 @@ func (p *WithModel) Connect(connect string) *WithModel {
 	return p
 }
 
+func (p *WithModel) Ctx(ctx context.Context) *WithModel {
+	p.WithOutModel.Ctx(ctx)
+	return p
+}
+
+// 查询也在主库上执行, link的查询同样使用主库
+func (p *WithModel) ForceMaster() *WithModel {
+	p.WithOutModel.ForceMaster()
+	return p
+}
+
 func (p *WithModel) Fields(fields ...string) *WithModel {
 	p.WithOutModel.Fields(fields...)
 	return p
@@ -73,6 +95,88 @@ func (p *WithModel) WhereIn(condition string, args ...interface{}) *WithModel {
 	return p
 }
 
+func (p *WithModel) OrWhere(condition string, args ...interface{}) *WithModel {
+	p.WithOutModel.OrWhere(condition, args...)
+	return p
+}
+
+func (p *WithModel) WhereNot(condition string, args ...interface{}) *WithModel {
+	p.WithOutModel.WhereNot(condition, args...)
+	return p
+}
+
+func (p *WithModel) WhereGroup(fn func(c *Cond)) *WithModel {
+	p.WithOutModel.WhereGroup(fn)
+	return p
+}
+
+func (p *WithModel) OrWhereGroup(fn func(c *Cond)) *WithModel {
+	p.WithOutModel.OrWhereGroup(fn)
+	return p
+}
+
+// 以下条件的column可以是struct的字段名, 会通过FieldMap转换为列名
+
+func (p *WithModel) WhereEq(column string, value interface{}) *WithModel {
+	p.WithOutModel.WhereEq(column, value)
+	return p
+}
+
+func (p *WithModel) WhereNe(column string, value interface{}) *WithModel {
+	p.WithOutModel.WhereNe(column, value)
+	return p
+}
+
+func (p *WithModel) WhereGt(column string, value interface{}) *WithModel {
+	p.WithOutModel.WhereGt(column, value)
+	return p
+}
+
+func (p *WithModel) WhereGte(column string, value interface{}) *WithModel {
+	p.WithOutModel.WhereGte(column, value)
+	return p
+}
+
+func (p *WithModel) WhereLt(column string, value interface{}) *WithModel {
+	p.WithOutModel.WhereLt(column, value)
+	return p
+}
+
+func (p *WithModel) WhereLte(column string, value interface{}) *WithModel {
+	p.WithOutModel.WhereLte(column, value)
+	return p
+}
+
+func (p *WithModel) WhereBetween(column string, min, max interface{}) *WithModel {
+	p.WithOutModel.WhereBetween(column, min, max)
+	return p
+}
+
+func (p *WithModel) WhereLike(column string, pattern string) *WithModel {
+	p.WithOutModel.WhereLike(column, pattern)
+	return p
+}
+
+func (p *WithModel) WhereNull(column string) *WithModel {
+	p.WithOutModel.WhereNull(column)
+	return p
+}
+
+func (p *WithModel) WhereNotNull(column string) *WithModel {
+	p.WithOutModel.WhereNotNull(column)
+	return p
+}
+
+func (p *WithModel) WhereInCol(column string, args ...interface{}) *WithModel {
+	p.WithOutModel.WhereInCol(column, args...)
+	return p
+}
+
+func (p *WithModel) WhereNotIn(column string, args ...interface{}) *WithModel {
+	p.WithOutModel.WhereNotIn(column, args...)
+	return p
+}
+
 func (p *WithModel) Limit(offset, size int) *WithModel {
 	p.WithOutModel.Limit(offset, size)
 	return p
@@ -83,70 +187,367 @@ func (p *WithModel) Order(field, desc string) *WithModel {
 	return p
 }
 
+// 连表查询, struct中 col(r.name) 这样的字段会从连接的表r中取值
+func (p *WithModel) Join(table, on string, args ...interface{}) *WithModel {
+	p.WithOutModel.Join(table, on, args...)
+	return p
+}
+
+func (p *WithModel) LeftJoin(table, on string, args ...interface{}) *WithModel {
+	p.WithOutModel.LeftJoin(table, on, args...)
+	return p
+}
+
+func (p *WithModel) RightJoin(table, on string, args ...interface{}) *WithModel {
+	p.WithOutModel.RightJoin(table, on, args...)
+	return p
+}
+
+// fields可以是struct的字段名
+func (p *WithModel) GroupBy(fields ...string) *WithModel {
+	for _, f := range fields {
+		p.WithOutModel.GroupBy(p.where.column(f))
+	}
+	return p
+}
+
+func (p *WithModel) Having(condition string, args ...interface{}) *WithModel {
+	p.WithOutModel.Having(condition, args...)
+	return p
+}
+
+// Insert与Update写入哪些字段:
+//  Insert: 写入非空值的字段; 空值的字段有default(...)时写入default的值(也会赋值到model里), 否则不写入
+//  Update: 写入所有字段; 有omitempty的字段为空值时不写入; 跟踪了原始值(Track)时只写入变化了的字段
+// 在此之上, Omit指定的字段总是不写入, MustCols指定的字段总是写入(即使为空值)
+// auto(...)与version字段由orm维护, 总是会写入
+
+// Insert/Update时忽略fields(struct字段名)
+func (p *WithModel) Omit(fields ...string) *WithModel {
+	p.omit = append(p.omit, fields...)
+	return p
+}
+
+// Insert/Update时必须写入fields(struct字段名), 即使是空值
+func (p *WithModel) MustCols(fields ...string) *WithModel {
+	p.must = append(p.must, fields...)
+	return p
+}
+
 func (p *WithModel) Insert(prtModel interface{}) (err error) {
 	if p.err != nil {
 		err = p.err
 		return
 	}
 
+	dbData, err := p.insertData(prtModel, nil, "insert")
+	if err != nil {
+		return
+	}
+
+	id, err := p.WithOutModel.
+		Insert(dbData)
+	if err != nil {
+		return
+	}
+
+	// 设置主键
+	err = p.setAutoPk(prtModel, id)
+	return
+}
+
+// 批量插入一个slice中的模型, ptrSliceModel可以是 *[]Model 或 *[]*Model
+// 每一行都会和Insert一样填充auto字段和转换值, 插入后回填自增主键
+// 同一条语句中的行写入相同的列: 某一行为空值而其他行不为空的字段, 写入default(...)的值或者空值
+// 自增主键除外: 指定了主键的行与没有指定的行会分成不同的语句插入, 指定的主键不会被回填覆盖
+// mysql回填的主键是根据LastInsertId连续推算的, 需要auto_increment_increment为1
+func (p *WithModel) InsertBatch(ptrSliceModel interface{}) (affect int64, err error) {
+	if p.err != nil {
+		err = p.err
+		return
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(ptrSliceModel))
+	if v.Kind() != reflect.Slice {
+		err = errors.New("InsertBatch need a ptr of slice")
+		return
+	}
+
+	l := v.Len()
+	models := make([]interface{}, l)
+	for i := 0; i < l; i++ {
+		item := v.Index(i)
+		if item.Kind() != reflect.Ptr {
+			item = item.Addr()
+		}
+		models[i] = item.Interface()
+	}
+
+	chunk := p.chunk
+	if chunk <= 0 {
+		chunk = DefaultChunkSize
+	}
+	autoPk := p.modelInfo.AutoPk
+	explicitPk := make([]bool, l) // 是否指定了自增主键的值
+	rows := make([]map[string]interface{}, l)
+	for start := 0; start < l; start += chunk {
+		end := start + chunk
+		if end > l {
+			end = l
+		}
+		// 这一批中任意一行不为空的字段, 每一行都要写入
+		fill := []string{}
+		for i := start; i < end; i++ {
+			for k, value := range util.ObjToMap(models[i], "") {
+				if util.IsEmptyValue(value) {
+					continue
+				}
+				if k == autoPk {
+					explicitPk[i] = true
+					continue
+				}
+				if !util.ItemInArray(k, fill) {
+					fill = append(fill, k)
+				}
+			}
+		}
+		for i := start; i < end; i++ {
+			rows[i], err = p.insertData(models[i], fill, "insert")
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	ids, affect, err := p.WithOutModel.insertMulti(rows)
+	if err != nil {
+		return
+	}
+
+	for i, id := range ids {
+		if !explicitPk[i] {
+			if err = p.setAutoPk(models[i], id); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (p *WithModel) Chunk(size int) *WithModel {
+	p.WithOutModel.Chunk(size)
+	return p
+}
+
+// 取得插入时要保存的列与值
+// 会过滤空值, 填充methods时的auto字段并转换值
+// fill中的字段为空值时也写入(有default时写入default的值), 用于批量插入时使每一行的列相同
+func (p *WithModel) insertData(prtModel interface{}, fill []string, methods ...string) (dbData map[string]interface{}, err error) {
 	fieldData := map[string]interface{}{}
 	// 读取保存的键值对
 	mapper := util.ObjToMap(prtModel, "")
 	for k, v := range mapper {
-		// 在插入的时候过滤空值
-		if !util.IsEmptyValue(v) {
+		if util.ItemInArray(k, p.omit) {
+			continue
+		}
+		// 在插入的时候过滤空值, MustCols指定的字段除外
+		if !util.IsEmptyValue(v) || util.ItemInArray(k, p.must) {
 			fieldData[k] = v
 		}
 	}
 	// 自动添加字段
-	autoSet, err := p.GetAutoSetField("insert")
-	if err != nil {
-		return
+	for _, method := range methods {
+		autoSet, e := p.GetAutoSetField(method)
+		if e != nil {
+			err = e
+			return
+		}
+		if autoSet != nil && len(autoSet) != 0 {
+			for k, v := range autoSet {
+				fieldData[k] = v
+			}
+			// 将自动添加的字段附加到model里，方便返回
+			if err = p.assign(prtModel, autoSet); err != nil {
+				return
+			}
+		}
 	}
-	if autoSet != nil && len(autoSet) != 0 {
-		for k, v := range autoSet {
-			fieldData[k] = v
+
+	// 空值使用default(...)指定的值, 先赋值到model里, 和其他字段一样转换后写入
+	defaults := map[string]interface{}{}
+	for field, value := range p.modelInfo.Defaults {
+		if _, ok := fieldData[field]; ok || util.ItemInArray(field, p.omit) {
+			continue
+		}
+		defaults[field] = value
+	}
+	if len(defaults) != 0 {
+		if err = p.assign(prtModel, defaults); err != nil {
+			return
+		}
+		values := util.ObjToMap(prtModel, "")
+		for field := range defaults {
+			fieldData[field] = values[field]
+		}
+	}
+	for _, field := range fill {
+		if _, ok := fieldData[field]; !ok && !util.ItemInArray(field, p.omit) {
+			fieldData[field] = mapper[field]
 		}
-		// 将自动添加的字段附加到model里，方便返回
-		util.MapToObj(prtModel, autoSet, "")
 	}
 
 	// 转换值
-	p.tranSaveData(&fieldData)
+	err = p.tranSaveData(&fieldData)
+	if err != nil {
+		return
+	}
 
 	// mapToDb
-	dbData := map[string]interface{}{}
-	for k, v := range fieldData {
-		dbKey, ok := p.modelInfo.FieldMap[k]
-		if ok {
-			dbData[dbKey] = v
-		}
+	dbData = p.toDbData(fieldData)
+	return
+}
+
+func (p *WithModel) OnConflict(fields ...string) *WithModel {
+	cols := make([]string, len(fields))
+	for i, f := range fields {
+		cols[i] = p.where.column(f)
+	}
+	p.WithOutModel.OnConflict(cols...)
+	return p
+}
+
+// 插入模型, 冲突时更新updateFields(struct字段名)
+// updateFields为空时更新除冲突列与只在insert时自动填充的字段外的所有列, auto(update)的字段总是会更新
+// 没有指定OnConflict时用主键判断冲突
+func (p *WithModel) Upsert(prtModel interface{}, updateFields ...string) (affect int64, err error) {
+	if p.err != nil {
+		err = p.err
+		return
 	}
 
-	id, err := p.WithOutModel.
-		Insert(dbData)
+	dbData, err := p.insertData(prtModel, nil, "insert", "update")
 	if err != nil {
 		return
 	}
 
-	// 设置主键
-	if p.modelInfo.AutoPk != "" && id != 0 {
-		util.MapToObj(prtModel, map[string]interface{}{
-			p.modelInfo.AutoPk: id,
-		}, "")
+	if len(p.conflict) == 0 {
+		p.OnConflict(p.modelInfo.Pks...)
+	}
+
+	// 只在insert时与会在update时自动填充的列
+	insertOnly, autoUpdate := []string{}, []string{}
+	for field, auto := range p.modelInfo.AutoFields {
+		whens := strings.Split(auto.When, "|")
+		if util.ItemInArray("update", whens) {
+			autoUpdate = append(autoUpdate, p.modelInfo.FieldMap[field])
+		} else if util.ItemInArray("insert", whens) {
+			insertOnly = append(insertOnly, p.modelInfo.FieldMap[field])
+		}
 	}
+	sort.Strings(autoUpdate)
 
+	update := []string{}
+	if len(updateFields) != 0 {
+		for _, f := range updateFields {
+			update = append(update, p.where.column(f))
+		}
+	} else {
+		for _, col := range sortedKeys(dbData) {
+			if !util.ItemInArray(col, p.conflict) && !util.ItemInArray(col, insertOnly) {
+				update = append(update, col)
+			}
+		}
+	}
+	for _, col := range autoUpdate {
+		if !util.ItemInArray(col, update) {
+			update = append(update, col)
+		}
+	}
+
+	affect, id, err := p.WithOutModel.upsert(dbData, update)
+	if err != nil {
+		return
+	}
+	err = p.setAutoPk(prtModel, id)
 	return
 }
 
+// 将插入得到的自增主键设置到model里
+func (p *WithModel) setAutoPk(prtModel interface{}, id int64) error {
+	if p.modelInfo.AutoPk == "" || id == 0 {
+		return nil
+	}
+	return p.assign(prtModel, map[string]interface{}{p.modelInfo.AutoPk: id})
+}
+
+// 将data(struct字段名 => 值)赋值到model里
+// 赋值失败时返回*AssignError, 宽松模式下只打印警告
+func (p *WithModel) assign(prtModel interface{}, data map[string]interface{}) error {
+	if _, errInfo := util.MapToObj(prtModel, data, ""); errInfo != "" {
+		return p.lenientErr(&AssignError{Table: p.table, Info: errInfo})
+	}
+	return nil
+}
+
+// 更新模型的所有字段
+// 模型嵌入了Track并且是通过Select读取的时, 只更新值发生了变化的字段
 func (p *WithModel) Update(prtModel interface{}) (count int64, err error) {
+	return p.update(prtModel, nil)
+}
+
+// 只更新fields(struct字段名)指定的字段, auto(update)与version字段总是会更新
+func (p *WithModel) UpdateColumns(prtModel interface{}, fields ...string) (count int64, err error) {
+	if len(fields) == 0 {
+		err = errors.New("UpdateColumns need fields")
+		return
+	}
+	return p.update(prtModel, fields)
+}
+
+func (p *WithModel) update(prtModel interface{}, fields []string) (count int64, err error) {
 	if p.err != nil {
 		err = p.err
 		return
 	}
+	// 在添加软删除与乐观锁的条件之前检查, 否则没有条件时也会更新所有行
+	if p.where.empty() {
+		err = fmt.Errorf("%w when UPDATE", ErrNoWhere)
+		return
+	}
+	p.scope()
 
 	// 读取保存的键值对
 	fieldData := util.ObjToMap(prtModel, "")
+	for k, v := range fieldData {
+		if util.ItemInArray(k, p.omit) ||
+			p.modelInfo.OmitEmpty[k] && util.IsEmptyValue(v) && !util.ItemInArray(k, p.must) {
+			delete(fieldData, k)
+		}
+	}
+	if fields != nil {
+		temp := map[string]interface{}{}
+		for _, f := range fields {
+			if v, ok := fieldData[f]; ok {
+				temp[f] = v
+			}
+		}
+		fieldData = temp
+	}
+
+	// 转换值
+	err = p.tranSaveData(&fieldData)
+	if err != nil {
+		return
+	}
+
+	// 只保留发生了变化的字段
+	if o := origin(prtModel); o != nil && fields == nil {
+		for k, v := range fieldData {
+			if !changed(o, k, v) && !util.ItemInArray(k, p.must) {
+				delete(fieldData, k)
+			}
+		}
+	}
 
 	// 自动添加字段
 	autoSet, err := p.GetAutoSetField("update")
@@ -154,36 +555,91 @@ func (p *WithModel) Update(prtModel interface{}) (count int64, err error) {
 		return
 	}
 	if autoSet != nil && len(autoSet) != 0 {
+		// 将自动添加的字段附加到model里，方便返回
+		if err = p.assign(prtModel, autoSet); err != nil {
+			return
+		}
+		err = p.tranSaveData(&autoSet)
+		if err != nil {
+			return
+		}
 		for k, v := range autoSet {
 			fieldData[k] = v
 		}
-		// 将自动添加的字段附加到model里，方便返回
-		util.MapToObj(prtModel, autoSet, "")
 	}
 
-	// 转换值
-	p.tranSaveData(&fieldData)
+	// 乐观锁
+	var version int64
+	versionField := p.modelInfo.Version
+	if versionField != "" {
+		version, err = toInt64(util.ObjToMap(prtModel, "")[versionField])
+		if err != nil {
+			return
+		}
+		// 原有条件中有OR时用括号包裹, 否则 a OR b AND version = ? 对a不生效
+		p.where.wrapOr()
+		p.WhereEq(versionField, version)
+		fieldData[versionField] = version + 1
+		if len(p.fields) != 0 {
+			p.fields = append(p.fields, p.modelInfo.FieldMap[versionField])
+		}
+	}
 
 	// mapToDb
-	dbData := map[string]interface{}{}
-	for k, v := range fieldData {
-		dbKey, ok := p.modelInfo.FieldMap[k]
-		if ok {
-			dbData[dbKey] = v
-		}
+	dbData := p.toDbData(fieldData)
+	if len(dbData) == 0 {
+		// 没有需要更新的字段
+		return
 	}
 
 	count, err = p.WithOutModel.
 		Update(dbData)
+	if err != nil {
+		return
+	}
+
+	if versionField != "" {
+		if count == 0 {
+			err = ErrStaleObject
+			return
+		}
+		if err = p.assign(prtModel, map[string]interface{}{versionField: version + 1}); err != nil {
+			return
+		}
+	}
+
+	if origin(prtModel) != nil {
+		p.snapshot(prtModel)
+	}
 
 	return
 }
 
+// 将struct字段名的键值对转换为列名的键值对
+// 连表查询用的 table.column 列与软删除的列不会被写入
+func (p *WithModel) toDbData(fieldData map[string]interface{}) map[string]interface{} {
+	dbData := map[string]interface{}{}
+	for k, v := range fieldData {
+		if k == p.modelInfo.SoftDelete {
+			continue
+		}
+		dbKey, ok := p.modelInfo.FieldMap[k]
+		if ok && !isQualifiedColumn(dbKey) {
+			dbData[dbKey] = v
+		}
+	}
+	return dbData
+}
+
 func (p *WithModel) Select(ptrSliceModel interface{}) (has bool, err error) {
 	if p.err != nil {
 		err = p.err
 		return
 	}
+	p.scope()
+	if len(p.joins) != 0 && len(p.fields) == 0 {
+		p.WithOutModel.Fields(p.joinFields()...)
+	}
 	// 是数组还是一个对象
 	isSlice := strings.Contains(reflect.TypeOf(ptrSliceModel).String(), "[")
 	if !isSlice {
@@ -194,12 +650,254 @@ func (p *WithModel) Select(ptrSliceModel interface{}) (has bool, err error) {
 	if err != nil || !has {
 		return
 	}
-	p.FromDbData(isSlice, result, ptrSliceModel)
+	err = p.FromDbData(isSlice, result, ptrSliceModel)
+	return
+}
+
+// 同Select, 没有满足条件的行时返回ErrNoRows
+func (p *WithModel) Get(ptrSliceModel interface{}) (err error) {
+	has, err := p.Select(ptrSliceModel)
+	if err == nil && !has {
+		err = ErrNoRows
+	}
+	return
+}
+
+// 查询第一条满足条件的行, 没有时初始化ptrModel:
+// 用WhereEq添加的条件值与attrs(struct字段名 => 值)会被赋值到ptrModel中
+func (p *WithModel) FirstOrInit(ptrModel interface{}, attrs map[string]interface{}) (found bool, err error) {
+	found, err = p.Select(ptrModel)
+	if err != nil || found {
+		return
+	}
+
+	col2Field := util.ReverseMap(p.modelInfo.FieldMap)
+	data := map[string]interface{}{}
+	for col, v := range p.where.eqValues() {
+		if field, ok := col2Field[col]; ok {
+			data[field] = v
+		}
+	}
+	for field, v := range attrs {
+		data[field] = v
+	}
+	err = p.assign(ptrModel, data)
+	return
+}
+
+// 同FirstOrInit, 没有找到时插入初始化后的ptrModel
+// 插入时主键冲突(被并发插入)会再查询一次
+func (p *WithModel) FirstOrCreate(ptrModel interface{}, attrs map[string]interface{}) (created bool, err error) {
+	found, err := p.FirstOrInit(ptrModel, attrs)
+	if err != nil || found {
+		return
+	}
+	err = p.Insert(ptrModel)
+	if errors.Is(err, ErrDuplicateKey) {
+		// 刚被其他请求插入, 从库可能还没有同步
+		p.master = true
+		err = p.Get(ptrModel)
+		return
+	}
+	created = err == nil
+	return
+}
+
+// 分页查询到ptrSliceModel中, page从1开始
+func (p *WithModel) Paginate(page, pageSize int, ptrSliceModel interface{}) (pg Page, err error) {
+	if p.err != nil {
+		err = p.err
+		return
+	}
+	p.scope()
+	pg, err = p.WithOutModel.paginate(page, pageSize)
+	if err != nil || pg.Total == 0 {
+		return
+	}
+	_, err = p.Select(ptrSliceModel)
+	return
+}
+
+func (p *WithModel) Count() (int64, error) {
+	p.scope()
+	return p.WithOutModel.Count()
+}
+
+func (p *WithModel) Exists() (bool, error) {
+	p.scope()
+	return p.WithOutModel.Exists()
+}
+
+// 以下聚合的column可以是struct的字段名
+
+func (p *WithModel) CountColumn(column string) (int64, error) {
+	p.scope()
+	return p.WithOutModel.CountColumn(p.where.qualify(p.where.column(column)))
+}
+
+func (p *WithModel) Sum(column string) (float64, error) {
+	p.scope()
+	return p.WithOutModel.Sum(p.where.qualify(p.where.column(column)))
+}
+
+func (p *WithModel) Max(column string) (float64, error) {
+	p.scope()
+	return p.WithOutModel.Max(p.where.qualify(p.where.column(column)))
+}
+
+func (p *WithModel) Min(column string) (float64, error) {
+	p.scope()
+	return p.WithOutModel.Min(p.where.qualify(p.where.column(column)))
+}
+
+func (p *WithModel) Avg(column string) (float64, error) {
+	p.scope()
+	return p.WithOutModel.Avg(p.where.qualify(p.where.column(column)))
+}
+
+// 表的别名, 没有别名时为表名
+func (p *WithModel) tableAlias() string {
+	name, alias := splitTable(p.table)
+	if alias == "" {
+		return name
+	}
+	return alias
+}
+
+// 连表查询时默认查询的字段
+// 本表的列加上表名(或别名)前缀, 防止与连接的表重名, 如 col(r.name) 这样的列会原样查询
+func (p *WithModel) joinFields() []string {
+	alias := p.tableAlias()
+	cols := make([]string, 0, len(p.modelInfo.FieldMap))
+	for _, col := range p.modelInfo.FieldMap {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	fields := make([]string, len(cols))
+	for i, col := range cols {
+		if isQualifiedColumn(col) {
+			fields[i] = col
+		} else {
+			fields[i] = p.quote(alias+"."+col) + " AS " + p.quote(col)
+		}
+	}
+	return fields
+}
+
+// 根据主键查询, keys的顺序与struct中pk字段的顺序相同
+func (p *WithModel) FindByPk(ptrModel interface{}, keys ...interface{}) (has bool, err error) {
+	if p.err != nil {
+		err = p.err
+		return
+	}
+	pks := p.modelInfo.Pks
+	if len(pks) == 0 {
+		err = errors.New("model has no pk, use tag `orm:\"pk\"` or `orm:\"pk(auto)\"`")
+		return
+	}
+	if len(keys) != len(pks) {
+		err = fmt.Errorf("FindByPk need %d keys, but got %d", len(pks), len(keys))
+		return
+	}
+	for i, field := range pks {
+		p.WhereEq(field, keys[i])
+	}
+	return p.Select(ptrModel)
+}
+
+// 保存模型, 主键有空值时插入, 否则根据主键判断记录是否存在, 存在则更新, 不存在则插入
+// 判断后被并发插入了同样主键的行(插入返回ErrDuplicateKey)时改为更新, 更新不到行时返回插入的错误
+// postgres中出错的语句会使事务失效, 在事务中并发Save同样的主键时需要自己加锁
+func (p *WithModel) Save(ptrModel interface{}) (err error) {
+	if p.err != nil {
+		err = p.err
+		return
+	}
+
+	// 已被软删除的行也算存在, 防止插入时主键冲突
+	// 在主库上判断, 避免从库延迟导致重复插入
+	q := p.newQuery().WithTrashed().ForceMaster()
+	err = q.wherePk(ptrModel)
+	if err != nil {
+		if len(p.modelInfo.Pks) == 0 {
+			return
+		}
+		// 主键为空
+		return p.Insert(ptrModel)
+	}
+	has, err := q.Exists()
+	if err != nil {
+		return
+	}
+	if !has {
+		err = p.Insert(ptrModel)
+		if !errors.Is(err, ErrDuplicateKey) {
+			return
+		}
+		insertErr := err
+		count, e := q.Update(ptrModel)
+		if e != nil {
+			return e
+		}
+		if count == 0 {
+			// 是其他唯一索引冲突
+			return insertErr
+		}
+		return nil
+	}
+	_, err = q.Update(ptrModel)
 	return
 }
 
+// 根据模型的主键删除
+func (p *WithModel) DeleteModel(ptrModel interface{}) (affect int64, err error) {
+	if p.err != nil {
+		err = p.err
+		return
+	}
+	q := p.newQuery()
+	err = q.wherePk(ptrModel)
+	if err != nil {
+		return
+	}
+	return q.Delete()
+}
+
+// 用模型的主键值作为where条件
+func (p *WithModel) wherePk(ptrModel interface{}) error {
+	pks := p.modelInfo.Pks
+	if len(pks) == 0 {
+		return errors.New("model has no pk, use tag `orm:\"pk\"` or `orm:\"pk(auto)\"`")
+	}
+	values := util.ObjToMap(ptrModel, "")
+	for _, field := range pks {
+		v, ok := values[field]
+		if !ok || util.IsEmptyValue(v) {
+			return fmt.Errorf("pk %s is empty", field)
+		}
+		p.WhereEq(field, v)
+	}
+	return nil
+}
+
+// 用相同的模型,表,连接与执行环境创建一个新的查询, 不带任何条件
+func (p *WithModel) newQuery() *WithModel {
+	q := &WithModel{modelInfo: p.modelInfo}
+	q.table = p.table
+	q.connect = p.connect
+	q.pk = p.pk
+	q.where.columns = p.modelInfo.FieldMap
+	q.trashed = p.trashed
+	q.omit = p.omit
+	q.must = p.must
+	q.inherit(&p.WithOutModel)
+	return q
+}
+
 // 将从db里取得的map赋值到model里
-func (p *WithModel) FromDbData(isSlice bool, result []map[string]interface{}, ptrSliceModel interface{}) {
+// 转换值或赋值失败时返回*TranError或*AssignError, 宽松模式下只打印警告
+func (p *WithModel) FromDbData(isSlice bool, result []map[string]interface{}, ptrSliceModel interface{}) (err error) {
 	col2Field := util.ReverseMap(p.modelInfo.FieldMap)
 	if isSlice {
 		structData := make([]map[string]interface{}, len(result))
@@ -212,16 +910,24 @@ func (p *WithModel) FromDbData(isSlice bool, result []map[string]interface{}, pt
 				}
 			}
 			// 转换值
-			p.tranStructData(&structItem)
+			err = p.tranStructData(&structItem)
+			if err != nil {
+				return
+			}
 			p.preLink(&structItem)
 			structData[i] = structItem
 		}
 
-		p.doLinkMulti(&structData)
+		if err = p.doLinkMulti(&structData); err != nil {
+			return
+		}
 		errInfo := util.MapListToObjList(ptrSliceModel, structData, "")
 		if errInfo != "" {
-			warn("table("+p.table+")", "tran", errInfo)
+			if err = p.lenientErr(&AssignError{Table: p.table, Info: errInfo}); err != nil {
+				return
+			}
 		}
+		p.snapshot(ptrSliceModel)
 	} else {
 		resultItem := result[0]
 		structItem := make(map[string]interface{}, len(resultItem))
@@ -232,14 +938,22 @@ func (p *WithModel) FromDbData(isSlice bool, result []map[string]interface{}, pt
 			}
 		}
 		// 转换值
-		p.tranStructData(&structItem)
-		p.doLink(&structItem)
-		//info("t",structItem)
+		err = p.tranStructData(&structItem)
+		if err != nil {
+			return
+		}
+		if err = p.doLink(&structItem); err != nil {
+			return
+		}
 		_, errInfo := util.MapToObj(ptrSliceModel, structItem, "")
 		if errInfo != "" {
-			warn("table("+p.table+")", "tran", errInfo)
+			if err = p.lenientErr(&AssignError{Table: p.table, Info: errInfo}); err != nil {
+				return
+			}
 		}
+		p.snapshot(ptrSliceModel)
 	}
+	return
 }
 
 type linkData struct {
@@ -266,11 +980,7 @@ func (p *WithModel) GetAutoSetField(method string) (needSet map[string]interface
 			if util.ItemInArray(method, strings.Split(auto.When, "|")) {
 				if auto.Typ == "time" {
 					// 判断类型
-					if strings.Contains(p.modelInfo.FieldTyp[field].String(), "int") {
-						needSet[field] = time.Now().Unix()
-					} else {
-						needSet[field] = time.Now().Format("2006-01-02 15:04:05")
-					}
+					needSet[field] = timeValue(p.modelInfo.FieldTyp[field].String())
 				}
 			}
 		}
@@ -331,7 +1041,7 @@ func (p *WithModel) preLink(data *map[string]interface{}) {
 
 		linkPtrValue := reflect.New(typ)
 
-		where := "`" + link.LinkKey + "` in (?) AND " + linkData.ExtCondition
+		where := p.quote(link.LinkKey) + " in (?) AND " + linkData.ExtCondition
 		one := InOneSql{
 			Table:      newWithModel(linkPtrValue.Interface()).GetTable(),
 			WhereField: strings.Trim(where, "AND "),
@@ -379,19 +1089,23 @@ type ResultKeyMap struct {
 	Value  string // 由于数据库读出来的值可能和存放link值类型不对应(在第一个orm时会转换类型), 这里就全部转换为string去对应
 }
 
-func (p *WithModel) doLinkMulti(data *[]map[string]interface{}) {
+// 连接对象的查询错误(如ctx取消)与转换, 赋值错误会返回, 宽松模式下后者只打印警告
+func (p *WithModel) doLinkMulti(data *[]map[string]interface{}) (err error) {
 	linkResult := map[ResultKeyMap]map[string]interface{}{} // onesql => key => model
 
 	// 查询数据库
 	for oneSql, pre := range p.preLinkData {
 		pre.Args = UnDuplicate(pre.Args)
-		rs, _, err := newWithOutModel().
+		w := newWithOutModel()
+		w.inherit(&p.WithOutModel)
+		rs, _, e := w.
 			Connect(p.connect).Table(oneSql.Table).
 			WhereIn(oneSql.WhereField, pre.Args...).
 			Select()
-		if err != nil {
-			info("err", err)
-			continue
+		if e != nil {
+			// ctx取消或超时等查询错误返回给调用方, 不能让link的字段静默缺失
+			err = e
+			return
 		}
 		for i, l := 0, len(rs); i < l; i++ {
 			r := rs[i]
@@ -422,7 +1136,7 @@ func (p *WithModel) doLinkMulti(data *[]map[string]interface{}) {
 
 			linkPtrValue := reflect.New(typ)
 
-			where := "`" + link.LinkKey + "` in (?) AND " + linkData.ExtCondition
+			where := p.quote(link.LinkKey) + " in (?) AND " + linkData.ExtCondition
 			linkModel := newWithModel(linkPtrValue.Interface())
 			one := InOneSql{
 				Table:      linkModel.GetTable(),
@@ -454,7 +1168,9 @@ func (p *WithModel) doLinkMulti(data *[]map[string]interface{}) {
 					}
 				}
 
-				linkModel.FromDbData(true, models, linkPtrValue.Interface())
+				if err = p.linkErr(linkModel.FromDbData(true, models, linkPtrValue.Interface())); err != nil {
+					return
+				}
 			} else {
 				vString, _ := util.Interface2StringWithType(val, false)
 				resultKeyMap := ResultKeyMap{
@@ -463,7 +1179,9 @@ func (p *WithModel) doLinkMulti(data *[]map[string]interface{}) {
 				}
 
 				if model, ok := linkResult[resultKeyMap]; ok {
-					linkModel.FromDbData(false, []map[string]interface{}{model}, linkPtrValue.Interface())
+					if err = p.linkErr(linkModel.FromDbData(false, []map[string]interface{}{model}, linkPtrValue.Interface())); err != nil {
+						return
+					}
 					has = true
 				}
 			}
@@ -473,12 +1191,12 @@ func (p *WithModel) doLinkMulti(data *[]map[string]interface{}) {
 			}
 		}
 	}
-
+	return
 }
 
 // 连接对象
 // todo 在需要多次link的时候, 优化查询相同表(where in)
-func (p *WithModel) doLink(data *map[string]interface{}) {
+func (p *WithModel) doLink(data *map[string]interface{}) (err error) {
 	p.preLinkData = nil
 
 	if p.link == nil || len(p.link) == 0 {
@@ -515,6 +1233,7 @@ func (p *WithModel) doLink(data *map[string]interface{}) {
 		}
 
 		m := newWithModel(linkPtrValue.Interface()).Fields(linkData.Column...)
+		m.inherit(&p.WithOutModel)
 		// 要连接的是否是一个slice
 		if typ.Kind() == reflect.Slice {
 			valValue := reflect.ValueOf(val)
@@ -530,15 +1249,21 @@ func (p *WithModel) doLink(data *map[string]interface{}) {
 				vs[i] = valValue.Index(i).Interface()
 			}
 
-			m = m.WhereIn("`"+link.LinkKey+"` in (?)", vs...)
+			m = m.WhereIn(m.quote(link.LinkKey)+" in (?)", vs...)
 		} else {
-			m = m.Where("`"+link.LinkKey+"` = ?", val)
+			m = m.Where(m.quote(link.LinkKey)+" = ?", val)
 		}
 
-		has, err := m.Select(linkPtrValue.Interface())
-
-		if err != nil {
-			warn("table("+p.table+")", err)
+		has, e := m.Select(linkPtrValue.Interface())
+		if e != nil {
+			if !isTranErr(e) {
+				// ctx取消或超时等查询错误
+				err = e
+				return
+			}
+			if err = p.linkErr(e); err != nil {
+				return
+			}
 			continue
 		}
 		if has {
@@ -550,49 +1275,75 @@ func (p *WithModel) doLink(data *map[string]interface{}) {
 }
 
 // 将db的值 转换为struct的值
-func (p *WithModel) tranStructData(saveData *map[string]interface{}) {
+func (p *WithModel) tranStructData(saveData *map[string]interface{}) (err error) {
+	return p.tran(saveData, false)
+}
+
+// 将struct的值 转换为db的值
+func (p *WithModel) tranSaveData(saveData *map[string]interface{}) (err error) {
+	return p.tran(saveData, true)
+}
+
+func (p *WithModel) tran(saveData *map[string]interface{}, input bool) (err error) {
 	for field, t := range p.modelInfo.Trans {
 		v, ok := (*saveData)[field]
 		if !ok {
 			continue
 		}
 
-		if traner, ok := translators[t.Typ]; ok {
-			data, err := traner.Output(field, p.modelInfo.FieldTyp[field], v)
-			//info("tran", v,data,t.Typ)
-
-			if err != nil {
-				warn("table("+p.table+")", "tran", err)
-				continue
+		traner, ok := getTranslator(t.Typ)
+		if !ok {
+			e := &TranError{Table: p.table, Field: field, Tran: t.Typ, Err: ErrTranslatorNotFound}
+			if err = p.lenientErr(e); err != nil {
+				return
 			}
-			(*saveData)[field] = data
-		} else {
-			warn("table("+p.table+")", "tran", "haven't traner named '"+t.Typ+"', forget register it ?")
 			continue
 		}
-	}
-	return
-}
 
-// 将struct的值 转换为db的值
-func (p *WithModel) tranSaveData(saveData *map[string]interface{}) {
-	for field, t := range p.modelInfo.Trans {
-		v, ok := (*saveData)[field]
-		if !ok {
-			continue
+		var data interface{}
+		var e error
+		if input {
+			data, e = traner.Input(field, p.modelInfo.FieldTyp[field], v)
+		} else {
+			data, e = traner.Output(field, p.modelInfo.FieldTyp[field], v)
 		}
-
-		if traner, ok := translators[t.Typ]; ok {
-			data, err := traner.Input(field, p.modelInfo.FieldTyp[field], v)
-			if err != nil {
-				warn("table("+p.table+")", "tran", err)
-				continue
+		if e != nil {
+			if err = p.lenientErr(&TranError{Table: p.table, Field: field, Tran: t.Typ, Err: e}); err != nil {
+				return
 			}
-			(*saveData)[field] = data
-		} else {
-			warn("table("+p.table+")", "tran", "haven't traner named '"+t.Typ+"', forget register it ?")
 			continue
 		}
+		(*saveData)[field] = data
 	}
 	return
 }
+
+// 宽松模式下只打印警告并返回nil
+// 连接对象的转换与赋值错误, 宽松模式下只打印警告
+func (p *WithModel) linkErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	return p.lenientErr(err)
+}
+
+// 是否是转换或赋值的错误
+func isTranErr(err error) bool {
+	var tranErr *TranError
+	var assignErr *AssignError
+	return errors.As(err, &tranErr) || errors.As(err, &assignErr)
+}
+
+func (p *WithModel) lenientErr(err error) error {
+	if p.lenient || Lenient {
+		warn("table("+p.table+")", err)
+		return nil
+	}
+	return err
+}
+
+// 转换与赋值出错时只打印警告而不返回错误, 字段会保持零值
+func (p *WithModel) Lenient() *WithModel {
+	p.lenient = true
+	return p
+}
@@ -1,8 +1,10 @@
 package orm
 
 import (
+	"context"
 	"errors"
-	"strings"
+	"fmt"
+	"github.com/bysir-zl/bygo/util"
 	"time"
 )
 
@@ -11,11 +13,25 @@ type WithOutModel struct {
 	connect string
 	table   string
 	fields  []string
-	where map[string]([]interface{}) // condition => args
-	order []orderItem
-	limit [2]int
+	joins   []joinItem
+	where   Cond
+	group   []string
+	having  Cond
+	order   []orderItem
+	limit   [2]int
+
+	tx     *Tx // 不为nil时在事务中执行
+	ctx    context.Context
+	master bool // 查询也使用主库
+
+	pk       string   // 自增主键的列名, 方言支持时INSERT会通过RETURNING取回
+	chunk    int      // 批量插入时每条语句最多插入的行数
+	conflict []string // Upsert时判断冲突的列
 }
 
+// 批量插入时默认每条语句插入的行数
+const DefaultChunkSize = 500
+
 type orderItem struct {
 	Field string
 	Desc  string
@@ -28,16 +44,12 @@ func newWithOutModel() *WithOutModel {
 }
 
 func (p *WithOutModel) ExecSql(sql string, args ...interface{}) (affectCount int64, lastInsertId int64, err error) {
-	c, err := config.writeConnect(p.connect)
-	if err != nil {
-		return
-	}
-	dbDriver, err := Singleton(c)
+	dbDriver, err := p.getDriver()
 	if err != nil {
 		return
 	}
 	t1 := time.Now()
-	att, insertId, err := dbDriver.Exec(sql, args...)
+	att, insertId, err := dbDriver.ExecContext(p.context(), sql, args...)
 	elapsed := time.Since(t1)
 	info("SQL : "+sql, args, elapsed)
 	if err != nil {
@@ -49,20 +61,44 @@ func (p *WithOutModel) ExecSql(sql string, args ...interface{}) (affectCount int
 
 	return
 }
+
+// 查询, 配置了从库时在从库上执行, 事务中或ForceMaster()后在主库上执行
 func (p *WithOutModel) QuerySql(sql string, args ...interface{}) (result []map[string]interface{}, err error) {
-	c, err := config.writeConnect(p.connect)
-	if err != nil {
-		return
+	return p.query(p.master, sql, args...)
+}
+
+// write为true时在主库上查询, 用于 INSERT ... RETURNING 这种会写入的语句
+// 从库连接出错时会被剔除, 并改在主库上重试
+func (p *WithOutModel) query(write bool, sql string, args ...interface{}) (result []map[string]interface{}, err error) {
+	var dbDriver *DbDriverMysql
+	var replica *Connect
+	if write || p.tx != nil {
+		dbDriver, err = p.getDriver()
+	} else {
+		var c *Connect
+		var isReplica bool
+		c, isReplica, err = config.readConnect(p.connect)
+		if err != nil {
+			return
+		}
+		if isReplica {
+			replica = c
+		}
+		dbDriver, err = Singleton(c)
 	}
-	dbDriver, err := Singleton(c)
 	if err != nil {
 		return
 	}
 
 	t1 := time.Now()
-	result, err = dbDriver.Query(sql, args...)
+	result, err = dbDriver.QueryContext(p.context(), sql, args...)
 	elapsed := time.Since(t1)
 	info("SQL : "+sql, args, elapsed)
+	if err != nil && replica != nil && isConnError(err) {
+		warn("replica", replica.Url, "ejected:", err)
+		ejectReplica(replica)
+		return p.query(true, sql, args...)
+	}
 	if err != nil {
 		return
 	}
@@ -70,6 +106,66 @@ func (p *WithOutModel) QuerySql(sql string, args ...interface{}) (result []map[s
 	return
 }
 
+// 取得执行写操作的driver, 在事务中则使用事务的连接
+func (p *WithOutModel) getDriver() (*DbDriverMysql, error) {
+	if p.tx != nil {
+		return p.tx.driver, nil
+	}
+	c, err := config.writeConnect(p.connect)
+	if err != nil {
+		return nil, err
+	}
+	return Singleton(c)
+}
+
+// 取得当前连接的sql方言
+func (p *WithOutModel) getDialect() (Dialect, error) {
+	if p.tx != nil {
+		return p.tx.driver.dialect, nil
+	}
+	c, err := config.writeConnect(p.connect)
+	if err != nil {
+		return nil, err
+	}
+	return c.Dialect(), nil
+}
+
+// 用当前连接的方言引用标识符
+func (p *WithOutModel) quote(name string) string {
+	d, err := p.getDialect()
+	if err != nil {
+		d = MysqlDialect{}
+	}
+	return quoteName(d, name)
+}
+
+// 继承parent的执行环境(事务,ctx等), 用于link等附加查询
+func (p *WithOutModel) inherit(parent *WithOutModel) {
+	p.tx = parent.tx
+	p.ctx = parent.ctx
+	p.master = parent.master
+}
+
+func (p *WithOutModel) context() context.Context {
+	if p.ctx == nil {
+		return context.Background()
+	}
+	return p.ctx
+}
+
+// 设置执行sql使用的ctx, ctx取消或超时时会中断正在执行的sql(包括link发出的查询)
+func (p *WithOutModel) Ctx(ctx context.Context) *WithOutModel {
+	p.ctx = ctx
+	return p
+}
+
+// 查询也在主库上执行, 用于写入后立即读取, 避免读到从库同步前的数据
+func (p *WithOutModel) ForceMaster() *WithOutModel {
+	p.master = true
+	return p
+}
+
+// table可以带别名, 如 "user u" 或 "user AS u"
 func (p *WithOutModel) Table(table string) *WithOutModel {
 	p.table = table
 	return p
@@ -95,30 +191,154 @@ func (p *WithOutModel) Limit(offset, size int) *WithOutModel {
 }
 
 func (p *WithOutModel) Where(condition string, args ...interface{}) *WithOutModel {
-	if p.where == nil {
-		p.where = map[string][]interface{}{}
-	}
-	p.where[condition] = args
+	p.where.Where(condition, args...)
+	return p
+}
+
+func (p *WithOutModel) OrWhere(condition string, args ...interface{}) *WithOutModel {
+	p.where.OrWhere(condition, args...)
+	return p
+}
+
+func (p *WithOutModel) WhereNot(condition string, args ...interface{}) *WithOutModel {
+	p.where.WhereNot(condition, args...)
 	return p
 }
 
 func (p *WithOutModel) WhereIn(condition string, args ...interface{}) *WithOutModel {
-	if args == nil || len(args) == 0 {
-		return p
-	}
-	if p.where == nil {
-		p.where = map[string][]interface{}{}
-	}
-	if !strings.Contains(condition, "(?)") {
-		p.err = errors.New("WhereIn condition must contains '(?)'")
-		return p
+	p.where.WhereIn(condition, args...)
+	p.condErr()
+	return p
+}
+
+// 用括号包裹fn中添加的条件, 与前面的条件用AND连接
+// Where("c = ?", 1).WhereGroup(func(c *Cond){ c.Where("a = ?", 1).OrWhere("b = ?", 2) })
+// => ( c = ? ) AND ( ( a = ? ) OR ( b = ? ) )
+func (p *WithOutModel) WhereGroup(fn func(c *Cond)) *WithOutModel {
+	p.where.WhereGroup(fn)
+	p.condErr()
+	return p
+}
+
+// 同WhereGroup, 与前面的条件用OR连接
+func (p *WithOutModel) OrWhereGroup(fn func(c *Cond)) *WithOutModel {
+	p.where.OrWhereGroup(fn)
+	p.condErr()
+	return p
+}
+
+// 以下条件会引用列名, 列名可以带表名前缀如 user.id
+
+func (p *WithOutModel) WhereEq(column string, value interface{}) *WithOutModel {
+	p.where.WhereEq(column, value)
+	return p
+}
+
+func (p *WithOutModel) WhereNe(column string, value interface{}) *WithOutModel {
+	p.where.WhereNe(column, value)
+	return p
+}
+
+func (p *WithOutModel) WhereGt(column string, value interface{}) *WithOutModel {
+	p.where.WhereGt(column, value)
+	return p
+}
+
+func (p *WithOutModel) WhereGte(column string, value interface{}) *WithOutModel {
+	p.where.WhereGte(column, value)
+	return p
+}
+
+func (p *WithOutModel) WhereLt(column string, value interface{}) *WithOutModel {
+	p.where.WhereLt(column, value)
+	return p
+}
+
+func (p *WithOutModel) WhereLte(column string, value interface{}) *WithOutModel {
+	p.where.WhereLte(column, value)
+	return p
+}
+
+func (p *WithOutModel) WhereBetween(column string, min, max interface{}) *WithOutModel {
+	p.where.WhereBetween(column, min, max)
+	return p
+}
+
+func (p *WithOutModel) WhereLike(column string, pattern string) *WithOutModel {
+	p.where.WhereLike(column, pattern)
+	return p
+}
+
+func (p *WithOutModel) WhereNull(column string) *WithOutModel {
+	p.where.WhereNull(column)
+	return p
+}
+
+func (p *WithOutModel) WhereNotNull(column string) *WithOutModel {
+	p.where.WhereNotNull(column)
+	return p
+}
+
+func (p *WithOutModel) WhereInCol(column string, args ...interface{}) *WithOutModel {
+	p.where.WhereInCol(column, args...)
+	return p
+}
+
+func (p *WithOutModel) WhereNotIn(column string, args ...interface{}) *WithOutModel {
+	p.where.WhereNotIn(column, args...)
+	return p
+}
+
+func (p *WithOutModel) condErr() {
+	if p.where.err != nil && p.err == nil {
+		p.err = p.where.err
 	}
-	s := strings.Repeat(",?", len(args))
-	condition = strings.Replace(condition, "(?)", "("+s[1:]+")", -1)
-	p.where[condition] = args
+}
+
+// JOIN table ON on, table可以带别名如 "role r"
+// 连表时Fields中 r.name 形式的字段会以 "r.name" 作为结果的键
+func (p *WithOutModel) Join(table, on string, args ...interface{}) *WithOutModel {
+	return p.join("JOIN", table, on, args)
+}
+
+func (p *WithOutModel) LeftJoin(table, on string, args ...interface{}) *WithOutModel {
+	return p.join("LEFT JOIN", table, on, args)
+}
+
+func (p *WithOutModel) RightJoin(table, on string, args ...interface{}) *WithOutModel {
+	return p.join("RIGHT JOIN", table, on, args)
+}
+
+func (p *WithOutModel) join(typ, table, on string, args []interface{}) *WithOutModel {
+	p.joins = append(p.joins, joinItem{Typ: typ, Table: table, On: on, Args: args})
+	return p
+}
+
+// GROUP BY fields
+func (p *WithOutModel) GroupBy(fields ...string) *WithOutModel {
+	p.group = append(p.group, fields...)
 	return p
 }
 
+// HAVING condition, 多次调用用AND连接, 只在有GroupBy时生效
+func (p *WithOutModel) Having(condition string, args ...interface{}) *WithOutModel {
+	p.having.Where(condition, args...)
+	return p
+}
+
+func (p *WithOutModel) selectParts() selectParts {
+	return selectParts{
+		Fields:  p.fields,
+		Table:   p.table,
+		Joins:   p.joins,
+		Where:   &p.where,
+		GroupBy: p.group,
+		Having:  &p.having,
+		Order:   p.order,
+		Limit:   p.limit,
+	}
+}
+
 func (p *WithOutModel) Order(field string, desc string) *WithOutModel {
 	if p.order == nil {
 		p.order = []orderItem{}
@@ -133,23 +353,181 @@ func (p *WithOutModel) Insert(saveData map[string]interface{}) (id int64, err er
 		return
 	}
 
-	if p.fields != nil {
-		// 过滤指定的字段
-		temp := map[string]interface{}{}
-		for _, k := range p.fields {
-			temp[k] = saveData[k]
+	saveData = p.filterFields(saveData)
+
+	d, err := p.getDialect()
+	if err != nil {
+		return
+	}
+	sql, args, err := buildInsertSql(d, p.table, saveData, p.pk)
+	if err != nil {
+		return
+	}
+
+	if p.pk != "" && d.Returning(p.pk) != "" {
+		// 通过RETURNING取回主键
+		var rs []map[string]interface{}
+		rs, err = p.query(true, sql, args...)
+		if err != nil {
+			return
 		}
-		saveData = temp
+		if len(rs) != 0 {
+			id, err = toInt64(rs[0][p.pk])
+		}
+		return
 	}
 
-	sql, args, err := buildInsertSql(p.table, saveData)
+	_, id, err = p.ExecSql(sql, args...)
 	if err != nil {
 		return
 	}
-	_, id, err = p.ExecSql(sql, args...)
+	return
+}
+
+// Upsert时判断冲突的列, postgres与sqlite需要指定, mysql根据主键与唯一索引判断
+func (p *WithOutModel) OnConflict(columns ...string) *WithOutModel {
+	p.conflict = columns
+	return p
+}
+
+// 插入一行, 冲突时更新updateFields指定的列, updateFields为空时更新除冲突列以外的所有列
+// mysql: INSERT ... ON DUPLICATE KEY UPDATE
+// postgres/sqlite: INSERT ... ON CONFLICT (...) DO UPDATE SET
+func (p *WithOutModel) Upsert(saveData map[string]interface{}, updateFields ...string) (affect int64, err error) {
+	affect, _, err = p.upsert(saveData, updateFields)
+	return
+}
+
+func (p *WithOutModel) upsert(saveData map[string]interface{}, update []string) (affect int64, id int64, err error) {
+	if p.err != nil {
+		err = p.err
+		return
+	}
+
+	saveData = p.filterFields(saveData)
+	if len(update) == 0 {
+		for _, key := range sortedKeys(saveData) {
+			if !util.ItemInArray(key, p.conflict) {
+				update = append(update, key)
+			}
+		}
+	}
+
+	d, err := p.getDialect()
+	if err != nil {
+		return
+	}
+	sql, args, err := buildUpsertSql(d, p.table, saveData, p.conflict, update, p.pk)
 	if err != nil {
 		return
 	}
+
+	if p.pk != "" && d.Returning(p.pk) != "" {
+		var rs []map[string]interface{}
+		rs, err = p.query(true, sql, args...)
+		if err != nil {
+			return
+		}
+		if len(rs) != 0 {
+			affect = 1
+			id, err = toInt64(rs[0][p.pk])
+		}
+		return
+	}
+
+	affect, id, err = p.ExecSql(sql, args...)
+	return
+}
+
+// 过滤出Fields指定的字段
+func (p *WithOutModel) filterFields(saveData map[string]interface{}) map[string]interface{} {
+	if p.fields == nil {
+		return saveData
+	}
+	temp := map[string]interface{}{}
+	for _, k := range p.fields {
+		temp[k] = saveData[k]
+	}
+	return temp
+}
+
+// 批量插入时每条INSERT语句最多插入size行, 默认DefaultChunkSize
+func (p *WithOutModel) Chunk(size int) *WithOutModel {
+	p.chunk = size
+	return p
+}
+
+// 批量插入, 生成 INSERT ... VALUES (...),(...) 语句
+// 行数超过Chunk时会分成多条语句执行, 需要保证原子性请在事务中调用
+func (p *WithOutModel) InsertMulti(rows []map[string]interface{}) (affect int64, err error) {
+	_, affect, err = p.insertMulti(rows)
+	return
+}
+
+// 批量插入, 返回每一行的自增主键, 取不到时为0
+// 相邻并且列相同的行才会合并到一条语句中
+func (p *WithOutModel) insertMulti(rows []map[string]interface{}) (ids []int64, affect int64, err error) {
+	if p.err != nil {
+		err = p.err
+		return
+	}
+	d, err := p.getDialect()
+	if err != nil {
+		return
+	}
+
+	chunk := p.chunk
+	if chunk <= 0 {
+		chunk = DefaultChunkSize
+	}
+	returning := p.pk != "" && d.Returning(p.pk) != ""
+
+	ids = make([]int64, len(rows))
+	for start := 0; start < len(rows); {
+		first := p.filterFields(rows[start])
+		batch := []map[string]interface{}{first}
+		end := start + 1
+		for ; end < len(rows) && end-start < chunk; end++ {
+			row := p.filterFields(rows[end])
+			if !sameKeys(first, row) {
+				break
+			}
+			batch = append(batch, row)
+		}
+
+		sql, args, e := buildInsertMultiSql(d, p.table, batch, p.pk)
+		if e != nil {
+			err = e
+			return
+		}
+
+		if returning {
+			rs, e := p.query(true, sql, args...)
+			if e != nil {
+				err = e
+				return
+			}
+			for i := 0; i < len(rs) && start+i < end; i++ {
+				ids[start+i], _ = toInt64(rs[i][p.pk])
+			}
+			affect += int64(len(rs))
+		} else {
+			count, lastId, e := p.ExecSql(sql, args...)
+			if e != nil {
+				err = e
+				return
+			}
+			if lastId != 0 {
+				firstId := d.FirstInsertId(lastId, len(batch))
+				for i := range batch {
+					ids[start+i] = firstId + int64(i)
+				}
+			}
+			affect += count
+		}
+
+		start = end
+	}
 	return
 }
 
@@ -158,12 +536,16 @@ func (p *WithOutModel) Delete() (affect int64, err error) {
 		err = p.err
 		return
 	}
-	if p.where == nil || len(p.where) == 0 {
-		err = errors.New("no where condition when DELETE")
+	if p.where.empty() {
+		err = fmt.Errorf("%w when DELETE", ErrNoWhere)
 		return
 	}
 
-	sql, args, err := buildDeleteSql(p.table, p.where)
+	d, err := p.getDialect()
+	if err != nil {
+		return
+	}
+	sql, args, err := buildDeleteSql(d, p.table, &p.where)
 	if err != nil {
 		return
 	}
@@ -179,8 +561,8 @@ func (p *WithOutModel) Update(saveData map[string]interface{}) (count int64, err
 		err = p.err
 		return
 	}
-	if p.where == nil || len(p.where) == 0 {
-		err = errors.New("no where condition when UPDATE")
+	if p.where.empty() {
+		err = fmt.Errorf("%w when UPDATE", ErrNoWhere)
 		return
 	}
 
@@ -193,7 +575,11 @@ func (p *WithOutModel) Update(saveData map[string]interface{}) (count int64, err
 		saveData = temp
 	}
 
-	sql, args, err := buildUpdateSql(p.table, saveData, p.where)
+	d, err := p.getDialect()
+	if err != nil {
+		return
+	}
+	sql, args, err := buildUpdateSql(d, p.table, saveData, &p.where)
 	if err != nil {
 		return
 	}
@@ -210,7 +596,11 @@ func (p *WithOutModel) Select() (result []map[string]interface{}, has bool, err
 		return
 	}
 
-	sql, args, err := buildSelectSql(p.fields, p.table, p.where, p.order, p.limit)
+	d, err := p.getDialect()
+	if err != nil {
+		return
+	}
+	sql, args, err := buildSelectSql(d, p.selectParts())
 	if err != nil {
 		return
 	}
@@ -236,3 +626,143 @@ func (p *WithOutModel) First() (result map[string]interface{}, has bool, err err
 	has = true
 	return
 }
+
+// 同First, 没有满足条件的行时返回ErrNoRows
+func (p *WithOutModel) FirstOrErr() (result map[string]interface{}, err error) {
+	result, has, err := p.First()
+	if err == nil && !has {
+		err = ErrNoRows
+	}
+	return
+}
+
+// 按当前的where条件统计行数 COUNT(*), 不受Fields影响
+func (p *WithOutModel) Count() (count int64, err error) {
+	return p.count("*")
+}
+
+// 统计column不为NULL的行数 COUNT(column)
+func (p *WithOutModel) CountColumn(column string) (count int64, err error) {
+	return p.count(p.quote(column))
+}
+
+func (p *WithOutModel) count(expr string) (count int64, err error) {
+	result, err := p.aggregate("COUNT", expr)
+	if err != nil {
+		return
+	}
+	count, err = toInt64(result)
+	return
+}
+
+// 是否有满足where条件的行
+func (p *WithOutModel) Exists() (has bool, err error) {
+	if p.err != nil {
+		err = p.err
+		return
+	}
+
+	d, err := p.getDialect()
+	if err != nil {
+		return
+	}
+	parts := p.selectParts()
+	parts.Fields = []string{"1"}
+	parts.Order = nil
+	parts.Limit = [2]int{0, 1}
+	sql, args, err := buildSelectSql(d, parts)
+	if err != nil {
+		return
+	}
+	result, err := p.QuerySql(sql, args...)
+	if err != nil {
+		return
+	}
+	has = len(result) != 0
+	return
+}
+
+// SUM(column), 没有满足条件的行时返回0
+func (p *WithOutModel) Sum(column string) (float64, error) {
+	return p.aggregateFloat("SUM", column)
+}
+
+func (p *WithOutModel) Max(column string) (float64, error) {
+	return p.aggregateFloat("MAX", column)
+}
+
+func (p *WithOutModel) Min(column string) (float64, error) {
+	return p.aggregateFloat("MIN", column)
+}
+
+func (p *WithOutModel) Avg(column string) (float64, error) {
+	return p.aggregateFloat("AVG", column)
+}
+
+func (p *WithOutModel) aggregateFloat(fn, column string) (result float64, err error) {
+	r, err := p.aggregate(fn, p.quote(column))
+	if err != nil {
+		return
+	}
+	result, err = toFloat64(r)
+	return
+}
+
+// 执行聚合函数, 返回db中读出的原始值
+func (p *WithOutModel) aggregate(fn, expr string) (result interface{}, err error) {
+	if p.err != nil {
+		err = p.err
+		return
+	}
+
+	d, err := p.getDialect()
+	if err != nil {
+		return
+	}
+	sql, args, err := buildAggregateSql(d, fn, expr, p.selectParts())
+	if err != nil {
+		return
+	}
+	rs, err := p.QuerySql(sql, args...)
+	if err != nil || len(rs) == 0 {
+		return
+	}
+	result = rs[0]["result"]
+	return
+}
+
+// 分页查询, page从1开始
+// 先用相同的where条件COUNT出总数, 再取出当前页的数据
+func (p *WithOutModel) Paginate(page, pageSize int) (result []map[string]interface{}, pg Page, err error) {
+	pg, err = p.paginate(page, pageSize)
+	if err != nil || pg.Total == 0 {
+		return
+	}
+	result, _, err = p.Select()
+	return
+}
+
+// 统计总数并设置limit
+func (p *WithOutModel) paginate(page, pageSize int) (pg Page, err error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		err = errors.New("pageSize must be greater than 0")
+		return
+	}
+
+	total, err := p.Count()
+	if err != nil {
+		return
+	}
+
+	pg = Page{
+		Total:     total,
+		PageTotal: int((total + int64(pageSize) - 1) / int64(pageSize)),
+		Page:      page,
+		PageSize:  pageSize,
+	}
+	p.Limit((page-1)*pageSize, pageSize)
+	return
+}